@@ -0,0 +1,222 @@
+package beat
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/monitoring/metrics"
+	"github.com/sebastianmontero/document-graph-elasticsearch/service"
+)
+
+// Batch accumulates write operations so that they can be executed in a single bulk request,
+// the cursor update is always included as the last operation of the request
+type Batch struct {
+	elasticSearch *service.ElasticSearch
+	config        *config.BulkConfig
+	cursorIndex   string
+	operations    []*service.BulkOperation
+	// Functions to call with the result of the operation at the same position once it is applied
+	onResult        map[int]func(result map[string]interface{})
+	overlay         *Overlay
+	metadata        *MetadataCache
+	catchUp         *CatchUp
+	cursor          string
+	persistedCursor string
+	started         time.Time
+	// Number of operations skipped because the document had the same or a newer version
+	conflicts uint64
+	// Set once a flush fails, from then on the cursor is not persisted
+	err error
+}
+
+// NewBatch creates a batch that stores the cursor in the specified cursor index, the overlay
+// is notified as the operations are flushed and the metadata of dropped documents is removed from the cache.
+// While catch up mode is active the batch grows up to the catch up max operations
+func NewBatch(elasticSearch *service.ElasticSearch, config *config.BulkConfig, cursorIndex string, overlay *Overlay, metadata *MetadataCache, catchUp *CatchUp) *Batch {
+	return &Batch{
+		elasticSearch: elasticSearch,
+		config:        config,
+		cursorIndex:   cursorIndex,
+		operations:    make([]*service.BulkOperation, 0),
+		onResult:      make(map[int]func(result map[string]interface{})),
+		overlay:       overlay,
+		metadata:      metadata,
+		catchUp:       catchUp,
+	}
+}
+
+// Adds an operation to the batch, the batch is flushed if the max operations or flush interval is reached
+func (m *Batch) Add(operation *service.BulkOperation) error {
+	if len(m.operations) == 0 {
+		m.started = time.Now()
+	}
+	m.operations = append(m.operations, operation)
+	return m.flushIfFull()
+}
+
+// Adds an operation to the batch, onResult is called with the result of the operation once it is applied,
+// it is not called if the operation fails
+func (m *Batch) AddWithResult(operation *service.BulkOperation, onResult func(result map[string]interface{})) error {
+	m.onResult[len(m.operations)] = onResult
+	return m.Add(operation)
+}
+
+// Sets the cursor to be stored with the batch, if there are no pending operations it is stored right away
+func (m *Batch) SetCursor(cursor string) error {
+	if m.err != nil {
+		return m.err
+	}
+	if len(m.operations) == 0 {
+		_, err := m.elasticSearch.Upsert(m.cursorIndex, CursorId, map[string]string{CursorProperty: cursor})
+		if err != nil {
+			return fmt.Errorf("failed updating cursor, name: %v, value: %v, error: %v", m.cursorIndex, cursor, err)
+		}
+		m.persistedCursor = cursor
+		m.cursor = ""
+		metrics.SetLastCheckpoint(time.Now())
+		return nil
+	}
+	m.cursor = cursor
+	return m.flushIfFull()
+}
+
+// Returns the error of the failed flush, once a flush fails the batch is dropped and the cursor is no longer
+// persisted, so that the process restarts from the last cursor whose operations were applied
+func (m *Batch) Err() error {
+	return m.err
+}
+
+// Returns the number of flushed operations that were skipped because the document had the same or a newer version
+func (m *Batch) Conflicts() uint64 {
+	return m.conflicts
+}
+
+// Returns the number of operations that have not been flushed
+func (m *Batch) Len() int {
+	return len(m.operations)
+}
+
+func (m *Batch) flushIfFull() error {
+	if uint(len(m.operations)) >= m.maxOperations() || time.Since(m.started) >= m.config.FlushInterval {
+		return m.Flush()
+	}
+	return nil
+}
+
+func (m *Batch) maxOperations() uint {
+	if m.catchUp != nil {
+		return m.catchUp.MaxOperations(m.config.MaxOperations)
+	}
+	return m.config.MaxOperations
+}
+
+// Executes the pending operations and the cursor update in a single bulk request. Operations rejected
+// because the document has a newer version are skipped, if any other operation or the request fails the whole
+// batch is dropped, as the rest of the request may have been applied and retrying only the failed operations would
+// break the order of the writes to a document. The cursor is set back to the last persisted one and the batch fails
+// from then on, so that the process restarts from that cursor
+func (m *Batch) Flush() error {
+	if m.err != nil {
+		return m.err
+	}
+	if len(m.operations) == 0 {
+		if m.cursor != "" {
+			return m.SetCursor(m.cursor)
+		}
+		return nil
+	}
+	operations := m.operations
+	if m.cursor != "" {
+		operations = append(operations, service.NewIndexOperation(m.cursorIndex, CursorId, map[string]string{CursorProperty: m.cursor}))
+	}
+	log.Debugf("Flushing batch, operations: %v, cursor: %v", len(operations), m.cursor)
+	metrics.BulkRequests.Inc()
+	res, err := m.elasticSearch.Bulk(operations)
+	if err != nil {
+		bulkError, ok := err.(*service.BulkError)
+		if !ok {
+			return m.drop(len(m.operations), err)
+		}
+		return m.handleBulkError(res, bulkError)
+	}
+	m.flushed(res)
+	return nil
+}
+
+func (m *Batch) handleBulkError(res map[string]interface{}, bulkError *service.BulkError) error {
+	failed := 0
+	for _, item := range bulkError.Items {
+		if item.IsVersionConflict() {
+			log.Infof("Bulk operation rejected, document has the same or a newer version, skipping: %v", item)
+			metrics.VersionConflicts.Inc()
+			m.conflicts++
+			m.invalidate(item.Operation)
+			continue
+		}
+		log.Errorf(item, "Bulk operation failed")
+		failed++
+	}
+	if failed == 0 {
+		m.flushed(res)
+		return nil
+	}
+	return m.drop(failed, bulkError)
+}
+
+// Called once the operations and the cursor have been applied, passes the result of each
+// successful operation to its result function
+func (m *Batch) flushed(res map[string]interface{}) {
+	if m.cursor != "" {
+		m.persistedCursor = m.cursor
+		metrics.SetLastCheckpoint(time.Now())
+	}
+	if len(m.onResult) > 0 {
+		results := service.BulkItemResults(res)
+		for i, onResult := range m.onResult {
+			if i < len(results) && results[i] != nil && results[i]["error"] == nil {
+				onResult(results[i])
+			}
+		}
+	}
+	if m.overlay != nil {
+		for _, operation := range m.operations {
+			m.overlay.Flushed(operation.Index, operation.DocumentId)
+		}
+	}
+	m.reset()
+}
+
+// Drops all the operations of the batch after a failed flush, the cursor is set back to the last persisted
+// one and the batch fails from then on
+func (m *Batch) drop(failed int, flushErr error) error {
+	metrics.BulkFailedOperations.Add(float64(failed))
+	for _, operation := range m.operations {
+		m.invalidate(operation)
+	}
+	m.reset()
+	m.err = fmt.Errorf("failed flushing batch, dropped it, cursor will not be persisted past: %v, error: %v", m.persistedCursor, flushErr)
+	if m.persistedCursor != "" {
+		_, err := m.elasticSearch.Upsert(m.cursorIndex, CursorId, map[string]string{CursorProperty: m.persistedCursor})
+		if err != nil {
+			return fmt.Errorf("failed restoring cursor: %v after batch failure: %v, error: %v", m.persistedCursor, m.err, err)
+		}
+	}
+	return m.err
+}
+
+// Removes the document of an operation that was not applied from the overlay and metadata cache
+func (m *Batch) invalidate(operation *service.BulkOperation) {
+	if m.overlay != nil {
+		m.overlay.Invalidate(operation.Index, operation.DocumentId)
+	}
+	if m.metadata != nil {
+		m.metadata.Remove(operation.Index, operation.DocumentId)
+	}
+}
+
+func (m *Batch) reset() {
+	m.operations = make([]*service.BulkOperation, 0)
+	m.onResult = make(map[int]func(result map[string]interface{}))
+	m.started = time.Now()
+}
@@ -0,0 +1,159 @@
+package beat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/monitoring/metrics"
+	"github.com/sebastianmontero/document-graph-elasticsearch/service"
+)
+
+var (
+	// Settings applied to the contract indexes while catching up
+	CatchUpSettings = map[string]interface{}{
+		"index.refresh_interval":   "-1",
+		"index.number_of_replicas": 0,
+	}
+	// Id of the cursor index document that holds the original settings while catching up
+	CatchUpId               = "catch-up"
+	CatchUpSettingsProperty = "settings"
+)
+
+// CatchUp switches the contract indexes to a bulk load profile while the processed blocks are far
+// behind the wall clock, and restores their original settings once the processed blocks get close to head.
+// The original settings are stored in the cursor index so that they can be restored on restart if the
+// process stops while catching up
+type CatchUp struct {
+	elasticSearch *service.ElasticSearch
+	config        *config.CatchUpConfig
+	cursorIndex   string
+	indexes       []string
+	active        int32
+}
+
+// NewCatchUp creates a catch up mode for the specified indexes
+func NewCatchUp(elasticSearch *service.ElasticSearch, config *config.CatchUpConfig, cursorIndex string, indexes []string) *CatchUp {
+	return &CatchUp{
+		elasticSearch: elasticSearch,
+		config:        config,
+		cursorIndex:   cursorIndex,
+		indexes:       indexes,
+	}
+}
+
+// Enters or exits catch up mode based on how far behind the wall clock the processed block is
+func (m *CatchUp) Update(blockTime time.Time) error {
+	if !m.config.Enabled {
+		return nil
+	}
+	lag := time.Since(blockTime)
+	if !m.IsActive() && lag >= m.config.EnterLag {
+		log.Infof("Block time: %v is: %v behind, entering catch up mode for indexes: %v", blockTime, lag, m.indexes)
+		return m.enter()
+	}
+	if m.IsActive() && lag <= m.config.ExitLag {
+		log.Infof("Block time: %v is: %v behind, exiting catch up mode for indexes: %v", blockTime, lag, m.indexes)
+		return m.Exit()
+	}
+	return nil
+}
+
+// Returns whether the indexes are in catch up mode
+func (m *CatchUp) IsActive() bool {
+	return atomic.LoadInt32(&m.active) == 1
+}
+
+// Returns the max operations of a bulk batch, which are increased while catching up
+func (m *CatchUp) MaxOperations(maxOperations uint) uint {
+	if m.IsActive() && m.config.MaxOperations > maxOperations {
+		return m.config.MaxOperations
+	}
+	return maxOperations
+}
+
+// Restores the original index settings if they were left in the bulk load profile, this happens
+// when the process stops while catching up
+func (m *CatchUp) Restore() error {
+	doc, err := m.elasticSearch.Get(m.cursorIndex, CatchUpId, nil)
+	if err != nil {
+		return fmt.Errorf("failed getting original index settings, index: %v, id: %v, error: %v", m.cursorIndex, CatchUpId, err)
+	}
+	if doc == nil {
+		return nil
+	}
+	original := make(map[string]map[string]interface{})
+	err = json.Unmarshal([]byte(doc[CatchUpSettingsProperty].(string)), &original)
+	if err != nil {
+		return fmt.Errorf("failed parsing original index settings: %v, error: %v", doc[CatchUpSettingsProperty], err)
+	}
+	log.Infof("Restoring original index settings: %v", original)
+	return m.restore(original)
+}
+
+func (m *CatchUp) enter() error {
+	original := make(map[string]map[string]interface{}, len(m.indexes))
+	for _, index := range m.indexes {
+		settings, err := m.elasticSearch.GetSettings(index)
+		if err != nil {
+			return fmt.Errorf("failed getting settings of index: %v, error: %v", index, err)
+		}
+		original[index] = make(map[string]interface{}, len(CatchUpSettings))
+		for setting := range CatchUpSettings {
+			original[index][setting] = settings[setting]
+		}
+	}
+	encoded, err := json.Marshal(original)
+	if err != nil {
+		return fmt.Errorf("failed marshalling original index settings: %v, error: %v", original, err)
+	}
+	_, err = m.elasticSearch.Upsert(m.cursorIndex, CatchUpId, map[string]string{CatchUpSettingsProperty: string(encoded)})
+	if err != nil {
+		return fmt.Errorf("failed storing original index settings: %s, error: %v", encoded, err)
+	}
+	m.elasticSearch.SuspendRefresh(true)
+	for _, index := range m.indexes {
+		_, err = m.elasticSearch.PutSettings(index, CatchUpSettings)
+		if err != nil {
+			return fmt.Errorf("failed applying catch up settings to index: %v, error: %v", index, err)
+		}
+	}
+	atomic.StoreInt32(&m.active, 1)
+	metrics.CatchUpMode.Set(1)
+	metrics.CatchUpTransitions.WithLabelValues("enter").Inc()
+	log.Infof("Entered catch up mode, original index settings: %s", encoded)
+	return nil
+}
+
+// Exits catch up mode restoring the original index settings, nothing is done if it is not active
+func (m *CatchUp) Exit() error {
+	if !m.IsActive() {
+		return nil
+	}
+	err := m.Restore()
+	if err != nil {
+		return err
+	}
+	metrics.CatchUpTransitions.WithLabelValues("exit").Inc()
+	log.Infof("Exited catch up mode")
+	return nil
+}
+
+func (m *CatchUp) restore(original map[string]map[string]interface{}) error {
+	for index, settings := range original {
+		_, err := m.elasticSearch.PutSettings(index, settings)
+		if err != nil {
+			return fmt.Errorf("failed restoring settings: %v of index: %v, error: %v", settings, index, err)
+		}
+	}
+	_, err := m.elasticSearch.DeleteDocument(m.cursorIndex, CatchUpId, false)
+	if err != nil {
+		return fmt.Errorf("failed deleting original index settings, index: %v, id: %v, error: %v", m.cursorIndex, CatchUpId, err)
+	}
+	m.elasticSearch.SuspendRefresh(false)
+	atomic.StoreInt32(&m.active, 0)
+	metrics.CatchUpMode.Set(0)
+	return nil
+}
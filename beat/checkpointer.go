@@ -0,0 +1,73 @@
+package beat
+
+import (
+	"time"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+)
+
+// Checkpointer decides when the cursor of the processed deltas is persisted based on the checkpoint
+// policy, so that the cursor does not have to be stored after every delta
+type Checkpointer struct {
+	policy   *config.CheckpointConfig
+	store    func(cursor string) error
+	pending  string
+	blockNum uint64
+	deltas   uint
+	last     time.Time
+}
+
+// NewCheckpointer creates a checkpointer that uses the store function to persist the cursor
+func NewCheckpointer(policy *config.CheckpointConfig, store func(cursor string) error) *Checkpointer {
+	return &Checkpointer{
+		policy: policy,
+		store:  store,
+		last:   time.Now(),
+	}
+}
+
+// Registers the cursor of a processed delta or heartbeat, the cursor is persisted if any of the
+// policy conditions is met. When checkpointing at block boundaries, the pending cursor is persisted
+// once a cursor from a different block is registered, as it is the last cursor of its block
+func (m *Checkpointer) Checkpoint(cursor string, blockNum uint64) error {
+	if m.policy.BlockBoundary && m.pending != "" && blockNum != m.blockNum {
+		err := m.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	m.pending = cursor
+	m.blockNum = blockNum
+	m.deltas++
+	if m.policy.IsEveryDelta() ||
+		(m.policy.Deltas > 0 && m.deltas >= m.policy.Deltas) ||
+		(m.policy.Interval > 0 && time.Since(m.last) >= m.policy.Interval) {
+		return m.Flush()
+	}
+	return nil
+}
+
+// Persists the pending cursor if there is one
+func (m *Checkpointer) Flush() error {
+	if m.pending == "" {
+		return nil
+	}
+	return m.Persist(m.pending)
+}
+
+// Persists the cursor immediately, discarding the pending cursor
+func (m *Checkpointer) Persist(cursor string) error {
+	err := m.store(cursor)
+	if err != nil {
+		return err
+	}
+	m.pending = ""
+	m.deltas = 0
+	m.last = time.Now()
+	return nil
+}
+
+// Returns the cursor that has been processed but not yet persisted
+func (m *Checkpointer) Pending() string {
+	return m.pending
+}
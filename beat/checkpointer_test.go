@@ -0,0 +1,64 @@
+package beat_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"gotest.tools/assert"
+)
+
+type cursorStore struct {
+	cursors []string
+}
+
+func (m *cursorStore) store(cursor string) error {
+	m.cursors = append(m.cursors, cursor)
+	return nil
+}
+
+func TestCheckpointEveryDelta(t *testing.T) {
+	store := &cursorStore{}
+	checkpointer := beat.NewCheckpointer(&config.CheckpointConfig{}, store.store)
+	assert.NilError(t, checkpointer.Checkpoint("c1", 1))
+	assert.NilError(t, checkpointer.Checkpoint("c2", 1))
+	assert.DeepEqual(t, store.cursors, []string{"c1", "c2"})
+	assert.Equal(t, checkpointer.Pending(), "")
+}
+
+func TestCheckpointEveryNDeltas(t *testing.T) {
+	store := &cursorStore{}
+	checkpointer := beat.NewCheckpointer(&config.CheckpointConfig{Deltas: 3}, store.store)
+	for i, cursor := range []string{"c1", "c2", "c3", "c4", "c5"} {
+		assert.NilError(t, checkpointer.Checkpoint(cursor, uint64(i)))
+	}
+	assert.DeepEqual(t, store.cursors, []string{"c3"})
+	assert.Equal(t, checkpointer.Pending(), "c5")
+	assert.NilError(t, checkpointer.Flush())
+	assert.DeepEqual(t, store.cursors, []string{"c3", "c5"})
+	assert.Equal(t, checkpointer.Pending(), "")
+}
+
+func TestCheckpointAtBlockBoundaries(t *testing.T) {
+	store := &cursorStore{}
+	checkpointer := beat.NewCheckpointer(&config.CheckpointConfig{BlockBoundary: true}, store.store)
+	assert.NilError(t, checkpointer.Checkpoint("c1", 1))
+	assert.NilError(t, checkpointer.Checkpoint("c2", 1))
+	assert.NilError(t, checkpointer.Checkpoint("c3", 2))
+	assert.NilError(t, checkpointer.Checkpoint("c4", 3))
+	assert.DeepEqual(t, store.cursors, []string{"c2", "c3"})
+	assert.Equal(t, checkpointer.Pending(), "c4")
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	store := &cursorStore{}
+	checkpointer := beat.NewCheckpointer(&config.CheckpointConfig{Interval: 50 * time.Millisecond}, store.store)
+	assert.NilError(t, checkpointer.Checkpoint("c1", 1))
+	assert.Equal(t, len(store.cursors), 0)
+	time.Sleep(60 * time.Millisecond)
+	assert.NilError(t, checkpointer.Checkpoint("c2", 2))
+	assert.DeepEqual(t, store.cursors, []string{"c2"})
+	assert.NilError(t, checkpointer.Persist("c3"))
+	assert.DeepEqual(t, store.cursors, []string{"c2", "c3"})
+}
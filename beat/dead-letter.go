@@ -0,0 +1,143 @@
+package beat
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/service"
+)
+
+var (
+	DeadLetterMappings = `
+		{
+			"mappings": {
+				"properties": {
+					"contract": {
+						"type": "keyword"
+					},
+					"tableName": {
+						"type": "keyword"
+					},
+					"blockNum": {
+						"type": "long"
+					},
+					"oldData": {
+						"type": "text",
+						"index": false
+					},
+					"newData": {
+						"type": "text",
+						"index": false
+					}
+				}
+			}
+		}
+	`
+)
+
+// DeadLetter stores a delta that could not be processed along with the error, so that
+// it can be replayed later
+type DeadLetter struct {
+	Id          string `json:"-"`
+	Contract    string `json:"contract"`
+	TableName   string `json:"tableName"`
+	Operation   string `json:"operation"`
+	PrimaryKey  string `json:"primaryKey"`
+	OldData     string `json:"oldData"`
+	NewData     string `json:"newData"`
+	BlockNum    uint64 `json:"blockNum"`
+	BlockId     string `json:"blockId"`
+	Cursor      string `json:"cursor"`
+	ForkStep    string `json:"forkStep"`
+	Error       string `json:"error"`
+	CreatedDate string `json:"createdDate"`
+}
+
+func (m *DeadLetter) String() string {
+	return fmt.Sprintf(
+		"DeadLetter{Id: %v, Contract: %v, TableName: %v, Operation: %v, PrimaryKey: %v, BlockNum: %v, Cursor: %v, ForkStep: %v, Error: %v}",
+		m.Id,
+		m.Contract,
+		m.TableName,
+		m.Operation,
+		m.PrimaryKey,
+		m.BlockNum,
+		m.Cursor,
+		m.ForkStep,
+		m.Error,
+	)
+}
+
+// Stores a dead letter in the contract dead letter index, the cursor and fork step are used as
+// id so that storing the same delta twice does not create duplicates
+func (m *DocumentBeat) StoreDeadLetter(deadLetter *DeadLetter, contractConfig *config.ContractConfig) error {
+	log.Warnf("Storing dead letter: %v", deadLetter)
+	index := contractConfig.DeadLetterIndexName
+	exists, err := m.IndexExists(index)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err = m.ElasticSearch.UpsertIndex(index, DeadLetterMappings)
+		if err != nil {
+			return fmt.Errorf("failed creating dead letter index: %v, error: %v", index, err)
+		}
+	}
+	deadLetter.Id = fmt.Sprintf("%v-%v", deadLetter.Cursor, deadLetter.ForkStep)
+	_, err = m.ElasticSearch.Upsert(index, deadLetter.Id, deadLetter)
+	if err != nil {
+		return fmt.Errorf("failed storing dead letter: %v, error: %v", deadLetter, err)
+	}
+	return nil
+}
+
+// Returns the oldest dead letters for the contract, skipping the first from dead letters
+func (m *DocumentBeat) GetDeadLetters(contractConfig *config.ContractConfig, from, size int) ([]*DeadLetter, error) {
+	index := contractConfig.DeadLetterIndexName
+	exists, err := m.IndexExists(index)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	query := map[string]interface{}{
+		"from": from,
+		"size": size,
+		"sort": []interface{}{
+			map[string]interface{}{
+				"blockNum": "asc",
+			},
+		},
+	}
+	res, err := m.ElasticSearch.Search(index, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting dead letters, index: %v, error: %v", index, err)
+	}
+	hits := service.Hits(res)
+	deadLetters := make([]*DeadLetter, 0, len(hits))
+	for _, hit := range hits {
+		source, err := json.Marshal(hit["_source"])
+		if err != nil {
+			return nil, fmt.Errorf("failed marshalling dead letter: %v, error: %v", hit, err)
+		}
+		deadLetter := &DeadLetter{}
+		err = json.Unmarshal(source, deadLetter)
+		if err != nil {
+			return nil, fmt.Errorf("failed unmarshalling dead letter: %v, error: %v", string(source), err)
+		}
+		deadLetter.Id = hit["_id"].(string)
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	return deadLetters, nil
+}
+
+// Deletes a dead letter, should be called once it has been successfully replayed
+func (m *DocumentBeat) DeleteDeadLetter(deadLetter *DeadLetter, contractConfig *config.ContractConfig) error {
+	_, err := m.ElasticSearch.DeleteDocument(contractConfig.DeadLetterIndexName, deadLetter.Id, false)
+	if err != nil {
+		return fmt.Errorf("failed deleting dead letter: %v, error: %v", deadLetter, err)
+	}
+	return nil
+}
@@ -3,20 +3,48 @@ package beat
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/monitoring/metrics"
 	"github.com/sebastianmontero/document-graph-elasticsearch/service"
 	"github.com/sebastianmontero/hypha-document-cache-gql-go/doccache/domain"
 	"github.com/sebastianmontero/slog-go/slog"
 )
 
 var (
-	CursorIndex                   = "cursor"
-	CursorId                      = "c1"
-	CursorProperty                = "cursor"
-	DocumentIndex                 = "documents"
-	FieldsPropertyName            = "fields"
-	EdgesPropertyName             = "edges"
+	CursorIndex              = "cursor"
+	CursorId                 = "c1"
+	CursorProperty           = "cursor"
+	SnapshotId               = "snapshot"
+	DocumentIndex            = "documents"
+	FieldsPropertyName       = "fields"
+	EdgesPropertyName        = "edges"
+	InEdgesPropertyName      = "in_edges"
+	EdgeCountsPropertyName   = "edge_counts"
+	InEdgeCountsPropertyName = "in_edge_counts"
+	// Maps the outgoing and incoming edge counts as integers, so that documents can be sorted and aggregated by them
+	EdgeCountsMappings = fmt.Sprintf(` {
+			"dynamic_templates": [
+				{
+					"%v": {
+						"path_match": "%v.*",
+						"mapping": {
+							"type": "integer"
+						}
+					}
+				},
+				{
+					"%v": {
+						"path_match": "%v.*",
+						"mapping": {
+							"type": "integer"
+						}
+					}
+				}
+			]
+		}
+	`, EdgeCountsPropertyName, EdgeCountsPropertyName, InEdgeCountsPropertyName, InEdgeCountsPropertyName)
 	SingleTextSearchFieldName     = "single_text_search_field"
 	SingleTextSearchFieldMappings = fmt.Sprintf(` {
 			"properties": {
@@ -32,13 +60,46 @@ var (
 		}
 	`, SingleTextSearchFieldMappings)
 
-	BaseIndex = `
-		{
-			"mappings": {}
+	// Adds the value to the outgoing or incoming edge if absent or removes it by value and updates the edge
+	// count, the update is a noop if the edge does not change
+	EdgeMutationScript = `
+		if (ctx._source[params.property] == null) {
+			ctx._source[params.property] = new HashMap();
+		}
+		def edge = ctx._source[params.property][params.edge];
+		if (params.delete) {
+			if (edge == null || !edge.removeIf(v -> v == params.value)) {
+				ctx.op = 'noop';
+			}
+		} else if (edge == null) {
+			ctx._source[params.property][params.edge] = [params.value];
+		} else if (edge.contains(params.value)) {
+			ctx.op = 'noop';
+		} else {
+			edge.add(params.value);
+		}
+		if (ctx.op != 'noop') {
+			if (ctx._source[params.countProperty] == null) {
+				ctx._source[params.countProperty] = new HashMap();
+			}
+			ctx._source[params.countProperty][params.edge] = ctx._source[params.property][params.edge].size();
 		}
 	`
+
+	BaseIndex = fmt.Sprintf(`
+		{
+			"mappings": %v
+		}
+	`, EdgeCountsMappings)
 )
 
+// Number of documents retrieved per search when looking for the documents that reference a deleted document
+var ReferencingDocsPageSize = 1000
+
+// Version increment between document writes within a block, leaves room for the version
+// increments caused by the partial updates done in between
+var VersionStep uint64 = 1 << 12
+
 var log *slog.Log
 
 type SingleTextSearchField struct {
@@ -52,21 +113,58 @@ func (m *SingleTextSearchField) AddValue(value interface{}, op config.SingleText
 	m.WriteString(fmt.Sprintf("%v ", value))
 }
 
-//DocumentBeat Service class to store and retrieve docs from elastic search
+// DocumentBeat Service class to store and retrieve docs from elastic search
 type DocumentBeat struct {
 	ElasticSearch *service.ElasticSearch
 	Cursor        string
 	Config        *config.Config
+	Journal       *UndoJournal
+	Finality      *FinalityTracker
+	Checkpointer  *Checkpointer
+	Batch         *Batch
+	Overlay       *Overlay
+	Metadata      *MetadataCache
+	CatchUp       *CatchUp
+	BlockNum      uint64
+	blockId       string
+	undoneBlockId string
+	commit        func(cursor string) error
+	version       uint64
+	resumed       bool
+	// Number of writes skipped because the stored document had the same or a newer version
+	conflicts uint64
+	// Number of edges queued until their missing document is stored, and of queued edges applied once it was
+	queuedEdges   uint64
+	resolvedEdges uint64
+	// Block after which the pending edges of each contract are purged next
+	pendingEdgesPurge map[string]uint64
 }
 
-//New creates a new DocumentBeat instance
+// New creates a new DocumentBeat instance
 func NewDocumentBeat(elasticSearch *service.ElasticSearch, config *config.Config, logConfig *slog.Config) (*DocumentBeat, error) {
 	log = slog.New(logConfig, "document-beat")
 
+	indexes := make([]string, 0, len(config.Contracts))
+	catchUpIndexes := make([]string, 0, len(config.Contracts))
+	for _, contract := range config.Contracts {
+		indexes = append(indexes, contract.IndexName)
+		catchUpIndexes = append(catchUpIndexes, contract.IndexName)
+		if contract.EdgesIndex {
+			catchUpIndexes = append(catchUpIndexes, contract.EdgesIndexName)
+		}
+	}
 	docbeat := &DocumentBeat{
 		ElasticSearch: elasticSearch,
 		Config:        config,
+		Journal:       NewUndoJournal(config.UndoJournalSize),
+		Overlay:       NewOverlay(config.OverlayTTL),
+		Metadata:      NewMetadataCache(config.MetadataCacheSize),
+		CatchUp:       NewCatchUp(elasticSearch, &config.CatchUp, config.CursorIndexName, catchUpIndexes),
+	}
+	if config.Bulk.Enabled {
+		docbeat.Batch = NewBatch(elasticSearch, &config.Bulk, config.CursorIndexName, docbeat.Overlay, docbeat.Metadata, docbeat.CatchUp)
 	}
+	docbeat.Checkpointer = NewCheckpointer(&config.Checkpoint, docbeat.storeCursor)
 	cursor, err := docbeat.GetCursor()
 
 	if err != nil {
@@ -78,90 +176,187 @@ func NewDocumentBeat(elasticSearch *service.ElasticSearch, config *config.Config
 	if err != nil {
 		return nil, fmt.Errorf("failed configuring indexes, error: %v", err)
 	}
+	if config.TrackFinality {
+		docbeat.Finality = NewFinalityTracker(elasticSearch, indexes)
+		docbeat.Finality.Start()
+	}
 	return docbeat, nil
 }
 
-//Creates or updates document
+// Creates a document beat for a contract worker, it shares the elastic search client, configuration and finality
+// tracker and catch up mode but has its own undo journal, overlay, metadata cache and batch, so that contracts can be processed in
+// parallel. Instead of storing the cursor, commit is called once all the writes up to the cursor have been flushed
+func (m *DocumentBeat) NewWorkerBeat(commit func(cursor string) error) *DocumentBeat {
+	docbeat := &DocumentBeat{
+		ElasticSearch: m.ElasticSearch,
+		Cursor:        m.Cursor,
+		Config:        m.Config,
+		Journal:       NewUndoJournal(m.Config.UndoJournalSize),
+		Finality:      m.Finality,
+		Overlay:       NewOverlay(m.Config.OverlayTTL),
+		Metadata:      NewMetadataCache(m.Config.MetadataCacheSize),
+		CatchUp:       m.CatchUp,
+		commit:        commit,
+	}
+	if m.Config.Bulk.Enabled {
+		docbeat.Batch = NewBatch(m.ElasticSearch, &m.Config.Bulk, m.Config.CursorIndexName, docbeat.Overlay, docbeat.Metadata, docbeat.CatchUp)
+	}
+	docbeat.Checkpointer = NewCheckpointer(&m.Config.Checkpoint, docbeat.storeCursor)
+	return docbeat
+}
+
+// Creates or updates document
 func (m *DocumentBeat) StoreDocument(chainDoc *domain.ChainDocument, cursor string, contractConfig *config.ContractConfig) error {
 	log.Infof("Storing chain document: %v, cursor: %v, contract config: %v", chainDoc, cursor, contractConfig)
 	doc, err := m.ToParsedDoc(chainDoc)
 	if err != nil {
 		return fmt.Errorf("failed storing document: %v, cursor: %v, contract config: %v, error: %v", chainDoc, cursor, contractConfig, err)
 	}
-	edges, err := m.GetDocument(chainDoc.GetDocId(), contractConfig.IndexName, []string{EdgesPropertyName})
+	edgeProperties := []string{EdgesPropertyName, InEdgesPropertyName, EdgeCountsPropertyName, InEdgeCountsPropertyName, LinkedPropertyName}
+	edges, err := m.GetDocument(chainDoc.GetDocId(), contractConfig.IndexName, append(edgeProperties, contractConfig.Projections.Fields()...))
 	if err != nil {
 		return fmt.Errorf("failed getting edges for document: %v, cursor: %v, contract config: %v, error: %v", chainDoc.GetDocId(), cursor, contractConfig, err)
 	}
 	if edges != nil {
-		if e, ok := edges[EdgesPropertyName]; ok {
-			doc[EdgesPropertyName] = e
+		for _, property := range edgeProperties {
+			if e, ok := edges[property]; ok {
+				doc[property] = e
+			}
 		}
 	}
+	if m.Config.TrackFinality {
+		doc[FinalityPropertyName] = FinalityReversible
+		doc[BlockNumPropertyName] = m.BlockNum
+	}
+	err = m.journalDocument(chainDoc.GetDocId(), contractConfig.IndexName)
+	if err != nil {
+		return fmt.Errorf("failed storing document: %v, cursor: %v, contract config: %v, error: %v", chainDoc.GetDocId(), cursor, contractConfig, err)
+	}
 	log.Infof("Storing parsed document: %v, cursor: %v", doc, cursor)
-	_, err = m.ElasticSearch.Upsert(contractConfig.IndexName, doc["docId"].(string), doc)
+	m.Metadata.Put(contractConfig.IndexName, chainDoc.GetDocId(), NewDocMetadata(doc))
+	err = m.upsert(contractConfig.IndexName, doc["docId"].(string), doc)
 	if err != nil {
+		m.Metadata.Remove(contractConfig.IndexName, chainDoc.GetDocId())
 		return fmt.Errorf("failed storing document: %v, cursor: %v, contract config: %v, error: %v", doc, cursor, contractConfig, err)
 	}
-	return m.UpdateCursor(cursor)
+	if edges != nil && len(contractConfig.Projections) > 0 {
+		err = m.propagateProjections(chainDoc.GetDocId(), edges, doc, contractConfig)
+		if err != nil {
+			return fmt.Errorf("failed propagating projections of document: %v, cursor: %v, contract config: %v, error: %v", chainDoc.GetDocId(), cursor, contractConfig, err)
+		}
+	}
+	if edges == nil && m.Config.PendingEdges.Enabled {
+		err = m.resolvePendingEdges(chainDoc.GetDocId(), cursor, contractConfig)
+		if err != nil {
+			return fmt.Errorf("failed resolving pending edges for document: %v, cursor: %v, contract config: %v, error: %v", chainDoc.GetDocId(), cursor, contractConfig, err)
+		}
+	}
+	return m.Checkpoint(cursor, m.BlockNum)
 }
 
-//Creates/Deletes an edge
-func (m *DocumentBeat) MutateEdge(chainEdge *domain.ChainEdge, deleteOp bool, cursor string, contractConfig *config.ContractConfig) error {
+// Creates/Deletes an edge, the created date of the edge row is stored in the edges index
+func (m *DocumentBeat) MutateEdge(chainEdge *domain.ChainEdge, createdDate string, deleteOp bool, cursor string, contractConfig *config.ContractConfig) error {
+	err := m.mutateEdge(newDatedChainEdge(chainEdge, createdDate), deleteOp, cursor, contractConfig)
+	if err != nil {
+		return err
+	}
+	return m.Checkpoint(cursor, m.BlockNum)
+}
+
+// Updates an edge, removing the old edge from the FROM document and adding the new one,
+// the created date of the new edge row is stored in the edges index
+func (m *DocumentBeat) UpdateEdge(oldChainEdge, newChainEdge *domain.ChainEdge, createdDate string, cursor string, contractConfig *config.ContractConfig) error {
+	log.Infof("Updating chain edge, old edge: %v, new edge: %v, cursor: %v, contract config: %v", oldChainEdge, newChainEdge, cursor, contractConfig)
+	if oldChainEdge.From == newChainEdge.From && oldChainEdge.To == newChainEdge.To && oldChainEdge.DocEdgeName == newChainEdge.DocEdgeName {
+		log.Infof("Edge update: %v, does not change from, to or name, skipping", newChainEdge)
+		return m.Checkpoint(cursor, m.BlockNum)
+	}
+	err := m.mutateEdge(newDatedChainEdge(oldChainEdge, ""), true, cursor, contractConfig)
+	if err != nil {
+		return fmt.Errorf("failed removing old edge: %v, error: %v", oldChainEdge, err)
+	}
+	err = m.mutateEdge(newDatedChainEdge(newChainEdge, createdDate), false, cursor, contractConfig)
+	if err != nil {
+		return fmt.Errorf("failed adding new edge: %v, error: %v", newChainEdge, err)
+	}
+	return m.Checkpoint(cursor, m.BlockNum)
+}
+
+// Creates/Deletes an edge, when pending edges are enabled the edges whose FROM or TO document does not exist
+// are queued until the document is stored, and deleting an edge removes it from the queue
+func (m *DocumentBeat) mutateEdge(chainEdge *datedChainEdge, deleteOp bool, cursor string, contractConfig *config.ContractConfig) error {
+	missingDocId, err := m.applyEdge(chainEdge, deleteOp, cursor, contractConfig)
+	if err != nil || missingDocId == "" || !m.Config.PendingEdges.Enabled {
+		return err
+	}
+	if deleteOp {
+		err = m.removePendingEdge(missingDocId, chainEdge.ChainEdge, contractConfig)
+	} else {
+		err = m.queuePendingEdge(missingDocId, NewPendingEdge(chainEdge.ChainEdge, chainEdge.createdDate, m.BlockNum), contractConfig)
+		m.queuedEdges++
+	}
+	if err != nil {
+		return fmt.Errorf("failed updating pending edge: %v, deleteOp: %v, cursor: %v, contract config: %v, error: %v", chainEdge, deleteOp, cursor, contractConfig, err)
+	}
+	return nil
+}
+
+// Returns the number of created edges that were queued because their FROM or TO document did not exist
+func (m *DocumentBeat) QueuedEdges() uint64 {
+	return m.queuedEdges
+}
+
+// Returns the number of queued edges that were applied once their missing document was stored
+func (m *DocumentBeat) ResolvedEdges() uint64 {
+	return m.resolvedEdges
+}
+
+// Applies an edge mutation to the FROM and TO documents, returns the id of the FROM or TO document
+// if it does not exist, in which case the edge is not applied
+func (m *DocumentBeat) applyEdge(chainEdge *datedChainEdge, deleteOp bool, cursor string, contractConfig *config.ContractConfig) (string, error) {
 	log.Infof("Mutating chain edge: %v, delete Op: %v, cursor: %v, contract config: %v", chainEdge, deleteOp, cursor, contractConfig)
 	edgeName := chainEdge.DocEdgeName
-	toFields := []string{"docId", "type"}
-	fromFields := append(toFields, fmt.Sprintf("%v.%v", EdgesPropertyName, edgeName))
-	docFrom, err := m.GetDocument(chainEdge.From, contractConfig.IndexName, fromFields)
+	docFrom, err := m.getMetadata(chainEdge.From, contractConfig.IndexName)
 	if err != nil {
-		return fmt.Errorf("failed getting document: %v, cursor: %v, contract config: %v, error: %v", chainEdge.From, cursor, contractConfig, err)
+		return "", fmt.Errorf("failed getting document: %v, cursor: %v, contract config: %v, error: %v", chainEdge.From, cursor, contractConfig, err)
 	}
 	if docFrom != nil {
-		log.Infof("Found FROM document: %v", docFrom)
-		docTo, err := m.GetDocument(chainEdge.To, contractConfig.IndexName, toFields)
+		log.Infof("Found FROM document: %v, type: %v", docFrom.DocId, docFrom.Type)
+		docTo, err := m.getMetadata(chainEdge.To, contractConfig.IndexName)
 		if err != nil {
-			return fmt.Errorf("failed getting document: %v, cursor: %v, contract config: %v, error: %v", chainEdge.To, cursor, contractConfig, err)
+			return "", fmt.Errorf("failed getting document: %v, cursor: %v, contract config: %v, error: %v", chainEdge.To, cursor, contractConfig, err)
 		}
 		if docTo != nil {
-			log.Infof("Found TO document: %v", docTo)
-			if fromType, ok := docFrom["type"].(string); ok {
-				if toType, ok := docTo["type"].(string); ok {
-					if !contractConfig.EdgeBlackList.IsBlackListed(fromType, toType, edgeName) {
-						log.Infof("Edge: %v, not black listed, mutating, deleteOp: %v", chainEdge, deleteOp)
-						edge := []interface{}{}
-						if edges, ok := docFrom[EdgesPropertyName].(map[string]interface{}); ok {
-							if e, ok := edges[edgeName].([]interface{}); ok {
-								edge = e
-							}
+			log.Infof("Found TO document: %v, type: %v", docTo.DocId, docTo.Type)
+			if docFrom.Type != "" {
+				if docTo.Type != "" {
+					if contractConfig.IsEdgeAllowed(docFrom.Type, docTo.Type, edgeName) {
+						log.Infof("Edge: %v, allowed, mutating, deleteOp: %v", chainEdge, deleteOp)
+						err := m.setEdgeValue(contractConfig.IndexName, docFrom.DocId, EdgesPropertyName, edgeName, docTo.DocId, deleteOp)
+						if err != nil {
+							return "", fmt.Errorf("failed updating document with updated edge: %v, cursor: %v, contract config: %v, error: %v", edgeName, cursor, contractConfig, err)
 						}
-						childId := docTo["docId"].(string)
-						pos := find(childId, edge)
-						wasUpdated := false
-						if pos == -1 && !deleteOp {
-							log.Infof("Adding docId: %v, to edge: %v for document: %v", childId, edgeName, chainEdge.From)
-							edge = append(edge, childId)
-							wasUpdated = true
-						} else if pos >= 0 && deleteOp {
-							log.Infof("Deleting docId: %v, from edge: %v for document: %v", childId, edgeName, chainEdge.From)
-							edge = append(edge[0:pos], edge[pos+1:]...)
-							wasUpdated = true
+						if projection := contractConfig.Projections.Get(edgeName); projection != nil {
+							err = m.mutateProjection(contractConfig.IndexName, docFrom.DocId, docTo.DocId, projection, deleteOp)
+							if err != nil {
+								return "", fmt.Errorf("failed updating projection of edge: %v, cursor: %v, contract config: %v, error: %v", edgeName, cursor, contractConfig, err)
+							}
 						}
-						if wasUpdated {
-							update := map[string]interface{}{
-								EdgesPropertyName: map[string]interface{}{
-									edgeName: edge,
-								},
+						if contractConfig.InEdges {
+							err = m.setEdgeValue(contractConfig.IndexName, docTo.DocId, InEdgesPropertyName, edgeName, docFrom.DocId, deleteOp)
+							if err != nil {
+								return "", fmt.Errorf("failed updating document with updated incoming edge: %v, cursor: %v, contract config: %v, error: %v", edgeName, cursor, contractConfig, err)
 							}
-							log.Infof("Updating document with updated edge: %v, update: %v, cursor: %v", edgeName, update, cursor)
-							_, err = m.ElasticSearch.Update(contractConfig.IndexName, docFrom["docId"].(string), update, false)
+						}
+						if contractConfig.EdgesIndex {
+							err = m.mutateEdgeDoc(chainEdge, docFrom.Type, docTo.Type, deleteOp, contractConfig)
 							if err != nil {
-								return fmt.Errorf("failed updating document with updated edge: %v, edge values: %v, cursor: %v, contract config: %v, error: %v", edgeName, edge, cursor, contractConfig, err)
+								return "", fmt.Errorf("failed updating edges index with edge: %v, deleteOp: %v, cursor: %v, contract config: %v, error: %v", chainEdge, deleteOp, cursor, contractConfig, err)
 							}
-						} else {
-							log.Warnf("Edge: %v, didn't cause an update, skipping", chainEdge)
 						}
 
 					} else {
-						log.Infof("Edge: %v, black listed, skipping", chainEdge)
+						log.Infof("Edge: %v, not allowed, skipping", chainEdge)
 					}
 				} else {
 					log.Warnf("Unable to process edge, TO Document: %v does not have a type, cursor: %v, contract config: %v", chainEdge.To, cursor, contractConfig)
@@ -171,22 +366,468 @@ func (m *DocumentBeat) MutateEdge(chainEdge *domain.ChainEdge, deleteOp bool, cu
 			}
 		} else {
 			log.Warnf("Unable to process edge, TO Document: %v not found, cursor: %v, contract config: %v", chainEdge.To, cursor, contractConfig)
+			return chainEdge.To, nil
 		}
 	} else {
 		log.Warnf("Unable to process edge, FROM Document: %v not found, cursor: %v, contract config: %v", chainEdge.From, cursor, contractConfig)
+		return chainEdge.From, nil
 	}
-	return m.UpdateCursor(cursor)
+	return "", nil
+}
+
+// Adds or removes a value from an outgoing or incoming edge of the document using a scripted update, the script
+// decides on the server whether the edge changes so that a stale metadata cache does not drop the mutation.
+// When bulk indexing is enabled the operation is added to the current batch and its result is applied once flushed
+func (m *DocumentBeat) setEdgeValue(index, docId, property, edgeName, value string, deleteOp bool) error {
+	countProperty := edgeCountsProperty(property)
+	params := map[string]interface{}{
+		"property":      property,
+		"countProperty": countProperty,
+		"edge":          edgeName,
+		"value":         value,
+		"delete":        deleteOp,
+	}
+	fields := []string{fmt.Sprintf("%v.%v", property, edgeName)}
+	log.Infof("Updating document: %v, %v: %v, value: %v, deleteOp: %v", docId, property, edgeName, value, deleteOp)
+	if m.Batch != nil {
+		m.Overlay.Script(index, docId)
+		operation := service.NewScriptedUpdateOperation(index, docId, EdgeMutationScript, params).WithFields(fields)
+		return m.Batch.AddWithResult(operation, func(result map[string]interface{}) {
+			m.edgeValueSet(index, docId, property, edgeName, value, deleteOp, result)
+		})
+	}
+	result, err := m.ElasticSearch.UpdateByScript(index, docId, EdgeMutationScript, params, fields, nil, nil)
+	if err != nil {
+		return err
+	}
+	m.edgeValueSet(index, docId, property, edgeName, value, deleteOp, result)
+	return nil
+}
+
+// Applies the result of an edge mutation, if the script updated the document the prior edge values are recorded
+// in the undo journal. The stored edge values are added to the overlay and metadata cache so that following reads reflect the write
+func (m *DocumentBeat) edgeValueSet(index, docId, property, edgeName, value string, deleteOp bool, result map[string]interface{}) {
+	get, _ := result["get"].(map[string]interface{})
+	source, ok := get["_source"].(map[string]interface{})
+	if !ok {
+		log.Warnf("Result of the mutation of %v: %v, document: %v does not include the stored edge, invalidating it", property, edgeName, docId)
+		m.Overlay.Invalidate(index, docId)
+		m.Metadata.Remove(index, docId)
+		return
+	}
+	edges, _ := source[property].(map[string]interface{})
+	edge := edgeValues(edges, edgeName)
+	if result["result"] == "updated" {
+		var priorEdge []interface{}
+		if deleteOp {
+			priorEdge = append(append(priorEdge, edge...), value)
+		} else if len(edge) > 1 {
+			priorEdge = make([]interface{}, 0, len(edge)-1)
+			for _, v := range edge {
+				if v != value {
+					priorEdge = append(priorEdge, v)
+				}
+			}
+		}
+		if property == InEdgesPropertyName {
+			m.Journal.RecordInEdge(index, docId, edgeName, priorEdge)
+		} else {
+			m.Journal.RecordEdge(index, docId, edgeName, priorEdge)
+		}
+	} else {
+		log.Infof("Mutation of %v: %v, document: %v, value: %v, deleteOp: %v didn't cause an update", property, edgeName, docId, value, deleteOp)
+	}
+	update := map[string]interface{}{
+		property: map[string]interface{}{
+			edgeName: edge,
+		},
+		edgeCountsProperty(property): map[string]interface{}{
+			edgeName: len(edge),
+		},
+	}
+	err := m.Overlay.Update(index, docId, update, false)
+	if err != nil {
+		log.Warnf("Failed adding mutation of %v: %v, document: %v to overlay, invalidating it, error: %v", property, edgeName, docId, err)
+		m.Overlay.Invalidate(index, docId)
+	}
+	m.Metadata.setEdge(index, docId, property, edgeName, edge)
+}
+
+// Returns the metadata of the document reflecting the results of its pending edge mutations,
+// the batch is flushed first if the document has a pending scripted update
+func (m *DocumentBeat) getEdgeMetadata(docId, docIndex string) (*DocMetadata, error) {
+	if m.Overlay.IsScripted(docIndex, docId) {
+		err := m.Flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m.getMetadata(docId, docIndex)
+}
+
+// Returns the metadata required to process edges for the specified document, the metadata cache
+// is checked first and the document is only read on a miss, returns nil if the document does not exist
+func (m *DocumentBeat) getMetadata(docId, docIndex string) (*DocMetadata, error) {
+	if metadata := m.Metadata.Get(docIndex, docId); metadata != nil {
+		return metadata, nil
+	}
+	doc, err := m.GetDocument(docId, docIndex, []string{"docId", "type", EdgesPropertyName, InEdgesPropertyName})
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, nil
+	}
+	metadata := NewDocMetadata(doc)
+	m.Metadata.Put(docIndex, docId, metadata)
+	return metadata, nil
 }
 
 // Deletes a document
 func (m *DocumentBeat) DeleteDocument(chainDoc *domain.ChainDocument, cursor string, contractConfig *config.ContractConfig) error {
 	log.Infof("Deleting chain document: %v, cursor: %v, contract config: %v", chainDoc, cursor, contractConfig)
-
-	_, err := m.ElasticSearch.DeleteDocument(contractConfig.IndexName, chainDoc.GetDocId(), false)
+	err := m.journalDocument(chainDoc.GetDocId(), contractConfig.IndexName)
 	if err != nil {
 		return fmt.Errorf("failed deleting document: %v, cursor: %v, contract config: %v, error: %v", chainDoc.GetDocId(), cursor, contractConfig, err)
 	}
-	return m.UpdateCursor(cursor)
+	if contractConfig.CascadeDelete {
+		err = m.removeDanglingEdges(chainDoc.GetDocId(), contractConfig)
+		if err != nil {
+			return fmt.Errorf("failed removing edges pointing to document: %v, cursor: %v, contract config: %v, error: %v", chainDoc.GetDocId(), cursor, contractConfig, err)
+		}
+	}
+	if contractConfig.InEdges {
+		err = m.removeInEdges(chainDoc.GetDocId(), contractConfig.IndexName)
+		if err != nil {
+			return fmt.Errorf("failed removing incoming edges of document: %v, cursor: %v, contract config: %v, error: %v", chainDoc.GetDocId(), cursor, contractConfig, err)
+		}
+	}
+	m.Metadata.Remove(contractConfig.IndexName, chainDoc.GetDocId())
+	err = m.delete(contractConfig.IndexName, chainDoc.GetDocId())
+	if err != nil {
+		return fmt.Errorf("failed deleting document: %v, cursor: %v, contract config: %v, error: %v", chainDoc.GetDocId(), cursor, contractConfig, err)
+	}
+	return m.Checkpoint(cursor, m.BlockNum)
+}
+
+// Removes the document from the incoming edges of the documents it points to, so that the
+// incoming edges do not reference deleted documents
+func (m *DocumentBeat) removeInEdges(docId, docIndex string) error {
+	doc, err := m.getEdgeMetadata(docId, docIndex)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return nil
+	}
+	for edgeName := range doc.Edges {
+		for _, toId := range doc.Edge(edgeName) {
+			docTo, err := m.getMetadata(fmt.Sprintf("%v", toId), docIndex)
+			if err != nil {
+				return err
+			}
+			if docTo == nil {
+				continue
+			}
+			err = m.setEdgeValue(docIndex, docTo.DocId, InEdgesPropertyName, edgeName, docId, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Removes the document from the edges of the documents that point to it, so that no edge references
+// the deleted document. The referencing documents are taken from the incoming edges of the document if they
+// are tracked, otherwise they are found by searching the edges of the contract documents
+func (m *DocumentBeat) removeDanglingEdges(docId string, contractConfig *config.ContractConfig) error {
+	index := contractConfig.IndexName
+	var (
+		referencing []string
+		err         error
+	)
+	if contractConfig.InEdges {
+		referencing, err = m.getInEdgeDocIds(docId, index)
+	} else {
+		referencing, err = m.searchReferencingDocIds(docId, index)
+	}
+	if err != nil {
+		return err
+	}
+	for _, fromId := range referencing {
+		docFrom, err := m.getEdgeMetadata(fromId, index)
+		if err != nil {
+			return err
+		}
+		if docFrom == nil {
+			continue
+		}
+		for edgeName := range docFrom.Edges {
+			if find(docId, docFrom.Edge(edgeName)) == -1 {
+				continue
+			}
+			log.Infof("Removing dangling edge: %v from document: %v to deleted document: %v", edgeName, fromId, docId)
+			err = m.setEdgeValue(index, fromId, EdgesPropertyName, edgeName, docId, true)
+			if err != nil {
+				return err
+			}
+			if projection := contractConfig.Projections.Get(edgeName); projection != nil {
+				err = m.setLinked(index, fromId, edgeName, docId, nil)
+				if err != nil {
+					return err
+				}
+			}
+			if contractConfig.EdgesIndex {
+				chainEdge := &domain.ChainEdge{
+					From:        fromId,
+					To:          docId,
+					DocEdgeName: edgeName,
+				}
+				err = m.mutateEdgeDoc(newDatedChainEdge(chainEdge, ""), "", "", true, contractConfig)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the ids of the documents stored in the incoming edges of the document
+func (m *DocumentBeat) getInEdgeDocIds(docId, docIndex string) ([]string, error) {
+	doc, err := m.getEdgeMetadata(docId, docIndex)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, nil
+	}
+	docIds := make([]string, 0)
+	for edgeName := range doc.InEdges {
+		for _, fromId := range doc.InEdge(edgeName) {
+			fromId := fmt.Sprintf("%v", fromId)
+			if !contains(docIds, fromId) {
+				docIds = append(docIds, fromId)
+			}
+		}
+	}
+	return docIds, nil
+}
+
+// Returns the ids of the documents that have an edge to the document, the edge values are matched exactly
+// and the results are paged using search_after. The index is refreshed first so that the search reflects the recent writes
+func (m *DocumentBeat) searchReferencingDocIds(docId, docIndex string) ([]string, error) {
+	err := m.refresh(docIndex)
+	if err != nil {
+		return nil, err
+	}
+	properties, err := m.mappedProperties(docIndex)
+	if err != nil {
+		return nil, err
+	}
+	edges, _ := properties[EdgesPropertyName].(map[string]interface{})
+	edgeProperties, _ := edges["properties"].(map[string]interface{})
+	should := make([]interface{}, 0, len(edgeProperties))
+	for name, mapping := range edgeProperties {
+		should = append(should, map[string]interface{}{
+			"terms": map[string]interface{}{
+				keywordField(fmt.Sprintf("%v.%v", EdgesPropertyName, name), mapping): []string{docId},
+			},
+		})
+	}
+	docIds := make([]string, 0)
+	if len(should) == 0 {
+		return docIds, nil
+	}
+	sortField := keywordField("docId", properties["docId"])
+	var searchAfter interface{}
+	for {
+		query := map[string]interface{}{
+			"size":    ReferencingDocsPageSize,
+			"_source": false,
+			"query": map[string]interface{}{
+				"bool": map[string]interface{}{
+					"should":               should,
+					"minimum_should_match": 1,
+				},
+			},
+			"sort": []interface{}{
+				map[string]interface{}{sortField: "asc"},
+			},
+		}
+		if searchAfter != nil {
+			query["search_after"] = searchAfter
+		}
+		res, err := m.ElasticSearch.Search(docIndex, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed searching documents with edges to document: %v, index: %v, error: %v", docId, docIndex, err)
+		}
+		hits := service.Hits(res)
+		for _, hit := range hits {
+			docIds = append(docIds, hit["_id"].(string))
+		}
+		if len(hits) < ReferencingDocsPageSize {
+			return docIds, nil
+		}
+		searchAfter = hits[len(hits)-1]["sort"]
+	}
+}
+
+// Flushes the pending operations and refreshes the index, so that searches reflect the recent writes even
+// if the refresh policy does not refresh on write or refreshes are suspended during catch up
+func (m *DocumentBeat) refresh(index string) error {
+	err := m.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = m.ElasticSearch.RefreshIndex(index)
+	return err
+}
+
+// Returns the mapped properties of the index
+func (m *DocumentBeat) mappedProperties(index string) (map[string]interface{}, error) {
+	res, err := m.ElasticSearch.GetMappings(index)
+	if err != nil {
+		return nil, err
+	}
+	mappings, _ := res["mappings"].(map[string]interface{})
+	properties, _ := mappings["properties"].(map[string]interface{})
+	return properties, nil
+}
+
+// Sets the block whose changes are going to be recorded in the undo journal, should be called
+// before processing the deltas of a new block. When bulk indexing is enabled the operations of the
+// previous block are flushed. The first block is the one the stream resumed from, its writes can not
+// be ordered with the ones done before the restart, so they are versioned with the highest version of
+// the block and only rejected if the stored document was written by a newer block
+func (m *DocumentBeat) BeginBlock(blockNum uint64, blockId string) error {
+	if m.blockId == "" {
+		m.resumed = true
+	} else if m.blockId != blockId {
+		if m.Batch != nil {
+			err := m.Batch.Flush()
+			if err != nil {
+				return fmt.Errorf("failed flushing operations of block: %v, id: %v, error: %v", m.BlockNum, m.blockId, err)
+			}
+		}
+		m.resumed = false
+	}
+	m.Journal.Begin(blockNum, blockId)
+	m.BlockNum = blockNum
+	m.blockId = blockId
+	m.undoneBlockId = ""
+	return nil
+}
+
+// Indicates that all blocks up to blockNum are irreversible, they are removed from the undo journal
+// and if finality is being tracked their documents are flipped to irreversible in the background
+func (m *DocumentBeat) MarkIrreversible(blockNum uint64) {
+	m.Journal.Prune(blockNum)
+	if m.Finality != nil {
+		m.Finality.MarkIrreversible(blockNum)
+	}
+}
+
+// Restores the documents and edges modified by the specified block to the state they had
+// before the block was applied. Returns false if the block is not in the undo journal, in which
+// case the reversed operations have to be processed instead
+func (m *DocumentBeat) UndoBlock(blockNum uint64, blockId, cursor string) (bool, error) {
+	// Pending edge mutations are journaled once their result is known
+	err := m.Flush()
+	if err != nil {
+		return false, fmt.Errorf("failed flushing before undoing block: %v, id: %v, cursor: %v, error: %v", blockNum, blockId, cursor, err)
+	}
+	m.Journal.End()
+	if m.undoneBlockId == blockId {
+		return true, m.Checkpoint(cursor, blockNum)
+	}
+	block := m.Journal.Pop(blockId)
+	if block == nil {
+		log.Warnf("Block: %v, id: %v not found in undo journal, processing reversed operations", blockNum, blockId)
+		return false, nil
+	}
+	log.Infof("Undoing block: %v, id: %v, entries: %v, cursor: %v", blockNum, blockId, len(block.Entries), cursor)
+	for i := len(block.Entries) - 1; i >= 0; i-- {
+		err := m.restoreJournalEntry(block.Entries[i])
+		if err != nil {
+			return false, fmt.Errorf("failed undoing block: %v, id: %v, cursor: %v, error: %v", blockNum, blockId, cursor, err)
+		}
+	}
+	m.undoneBlockId = blockId
+	return true, m.Checkpoint(cursor, blockNum)
+}
+
+// Records the state of the document before it is modified in the current block
+func (m *DocumentBeat) journalDocument(docId, docIndex string) error {
+	if !m.Journal.IsRecording() || m.Journal.HasTouched(docIndex, docId, "") {
+		return nil
+	}
+	doc, err := m.GetDocument(docId, docIndex, nil)
+	if err != nil {
+		return fmt.Errorf("failed journaling document: %v, index: %v, error: %v", docId, docIndex, err)
+	}
+	m.Journal.RecordDocument(docIndex, docId, doc)
+	return nil
+}
+
+// Restores a document or edge to the state recorded in the journal entry
+func (m *DocumentBeat) restoreJournalEntry(entry *JournalEntry) error {
+	log.Infof("Restoring journal entry: %v", entry)
+	m.Metadata.Remove(entry.Index, entry.DocId)
+	if !entry.IsEdge() {
+		if entry.Prior == nil {
+			err := m.delete(entry.Index, entry.DocId)
+			if err != nil {
+				return fmt.Errorf("failed restoring journal entry: %v, error: %v", entry, err)
+			}
+			return nil
+		}
+		err := m.upsert(entry.Index, entry.DocId, entry.Prior)
+		if err != nil {
+			return fmt.Errorf("failed restoring journal entry: %v, error: %v", entry, err)
+		}
+		return nil
+	}
+	doc, err := m.GetDocument(entry.DocId, entry.Index, nil)
+	if err != nil {
+		return fmt.Errorf("failed restoring journal entry: %v, error: %v", entry, err)
+	}
+	if doc == nil {
+		log.Warnf("Document for journal entry: %v not found, skipping", entry)
+		return nil
+	}
+	var count interface{}
+	if prior, ok := entry.Prior.([]interface{}); ok {
+		count = len(prior)
+	}
+	setEdgeProperty(doc, entry.Property, entry.EdgeName, entry.Prior)
+	setEdgeProperty(doc, edgeCountsProperty(entry.Property), entry.EdgeName, count)
+	err = m.upsert(entry.Index, entry.DocId, doc)
+	if err != nil {
+		return fmt.Errorf("failed restoring journal entry: %v, error: %v", entry, err)
+	}
+	return nil
+}
+
+// Stops the background processes, should be called before exiting
+func (m *DocumentBeat) Close() {
+	err := m.Flush()
+	if err != nil {
+		log.Error(err, "Failed flushing pending operations on close")
+	}
+	err = m.CatchUp.Exit()
+	if err != nil {
+		log.Error(err, "Failed exiting catch up mode on close")
+	}
+	if m.Finality != nil {
+		m.Finality.Stop()
+	}
+}
+
+// Registers the cursor of a processed delta, the cursor is persisted based on the checkpoint policy
+func (m *DocumentBeat) Checkpoint(cursor string, blockNum uint64) error {
+	return m.Checkpointer.Checkpoint(cursor, blockNum)
 }
 
 // Updates the cursor stored on the db
@@ -196,6 +837,143 @@ func (m *DocumentBeat) UpdateCursor(cursor string) error {
 	if err != nil {
 		return fmt.Errorf("failed updating cursor, name: %v, value: %v, error: %v", m.Config.CursorIndexName, cursor, err)
 	}
+	metrics.SetLastCheckpoint(time.Now())
+	return nil
+}
+
+// Stores the cursor, when bulk indexing is enabled it is stored as the last operation of the current batch.
+// Worker beats flush the pending operations and commit the cursor instead
+func (m *DocumentBeat) storeCursor(cursor string) error {
+	if m.commit != nil {
+		err := m.Flush()
+		if err != nil {
+			return err
+		}
+		return m.commit(cursor)
+	}
+	if m.Batch != nil {
+		return m.Batch.SetCursor(cursor)
+	}
+	return m.UpdateCursor(cursor)
+}
+
+// Executes the pending bulk operations, does nothing if bulk indexing is not enabled
+func (m *DocumentBeat) Flush() error {
+	if m.Batch == nil {
+		return nil
+	}
+	return m.Batch.Flush()
+}
+
+// Returns the number of writes skipped because the stored document had the same or a newer version,
+// including the flushed bulk operations
+func (m *DocumentBeat) Conflicts() uint64 {
+	if m.Batch == nil {
+		return m.conflicts
+	}
+	return m.conflicts + m.Batch.Conflicts()
+}
+
+// Returns the external version for the next document write, derived from the block number and the
+// ordinal of the write within the block, so that replayed writes are rejected. After a block is undone
+// versions keep increasing from the last one issued, so that the restored documents and the writes of
+// the new fork are not rejected. The writes of the resumed block all get the highest version of the block.
+// Returns nil if no block has been started
+func (m *DocumentBeat) nextVersion() *int {
+	if m.blockId == "" {
+		return nil
+	}
+	if m.resumed {
+		ceiling := (m.BlockNum+1)<<32 - 1
+		if m.version < ceiling {
+			m.version = ceiling
+		}
+		version := int(m.version)
+		return &version
+	}
+	base := m.BlockNum << 32
+	if m.version > base {
+		base = m.version
+	}
+	m.version = base + VersionStep
+	version := int(m.version)
+	return &version
+}
+
+// Called when a versioned write is rejected because the stored document is newer, the write is
+// treated as a no-op and the document is removed from the overlay and metadata cache
+func (m *DocumentBeat) skipConflict(index, docId string, err error) {
+	log.Infof("Document: %v, index: %v has the same or a newer version, skipping write, error: %v", docId, index, err)
+	metrics.VersionConflicts.Inc()
+	m.conflicts++
+	m.Overlay.Invalidate(index, docId)
+	m.Metadata.Remove(index, docId)
+}
+
+// Creates or replaces a document, when bulk indexing is enabled the operation is added to the current batch,
+// the document is added to the overlay so that following reads reflect the write
+func (m *DocumentBeat) upsert(index, docId string, doc interface{}) error {
+	version := m.nextVersion()
+	if m.Batch != nil {
+		err := m.Overlay.Put(index, docId, doc, true)
+		if err != nil {
+			return err
+		}
+		operation := service.NewIndexOperation(index, docId, doc)
+		if version != nil {
+			operation.WithVersion(*version, m.versionType(version))
+		}
+		return m.Batch.Add(operation)
+	}
+	_, err := m.ElasticSearch.UpsertWithVersion(index, docId, doc, version, m.versionType(version))
+	if service.IsVersionConflict(err) {
+		m.skipConflict(index, docId, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return m.Overlay.Put(index, docId, doc, false)
+}
+
+// Updates the specified fields of a document, when bulk indexing is enabled the operation is added to the current batch,
+// the update is added to the overlay so that following reads reflect the write
+func (m *DocumentBeat) update(index, docId string, update map[string]interface{}) error {
+	if m.Batch != nil {
+		err := m.Overlay.Update(index, docId, update, true)
+		if err != nil {
+			return err
+		}
+		return m.Batch.Add(service.NewUpdateOperation(index, docId, update, false))
+	}
+	_, err := m.ElasticSearch.Update(index, docId, update, false)
+	if err != nil {
+		return err
+	}
+	return m.Overlay.Update(index, docId, update, false)
+}
+
+// Deletes a document, when bulk indexing is enabled the operation is added to the current batch,
+// the deletion is added to the overlay so that following reads reflect the write
+func (m *DocumentBeat) delete(index, docId string) error {
+	version := m.nextVersion()
+	if m.Batch != nil {
+		m.Overlay.Delete(index, docId, true)
+		operation := service.NewDeleteOperation(index, docId)
+		if version != nil {
+			operation.WithVersion(*version, m.versionType(version))
+		}
+		return m.Batch.Add(operation)
+	}
+	_, err := m.ElasticSearch.DeleteDocumentWithVersion(index, docId, false, version, m.versionType(version))
+	if service.IsVersionConflict(err) {
+		m.skipConflict(index, docId, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.Overlay.Delete(index, docId, false)
 	return nil
 }
 
@@ -218,6 +996,28 @@ func (m *DocumentBeat) GetCursor() (string, error) {
 	return doc[CursorProperty].(string), nil
 }
 
+// Records the block at which the snapshot of the contract tables was taken, it is stored in the cursor index
+func (m *DocumentBeat) StoreSnapshotBlock(blockNum uint64) error {
+	_, err := m.ElasticSearch.Upsert(m.Config.CursorIndexName, SnapshotId, map[string]interface{}{BlockNumPropertyName: blockNum})
+	if err != nil {
+		return fmt.Errorf("failed storing snapshot block: %v, index: %v, error: %v", blockNum, m.Config.CursorIndexName, err)
+	}
+	return nil
+}
+
+// Returns the block at which the last snapshot was taken, 0 if there is no snapshot
+func (m *DocumentBeat) GetSnapshotBlock() (uint64, error) {
+	doc, err := m.ElasticSearch.Get(m.Config.CursorIndexName, SnapshotId, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed getting snapshot block, index: %v, id: %v, error: %v", m.Config.CursorIndexName, SnapshotId, err)
+	}
+	if doc == nil {
+		return 0, nil
+	}
+	blockNum, _ := doc[BlockNumPropertyName].(float64)
+	return uint64(blockNum), nil
+}
+
 // Checks whether a cursor already exists
 func (m *DocumentBeat) CursorExists() (bool, error) {
 	log.Infof("Checking if cursor exists")
@@ -230,7 +1030,7 @@ func (m *DocumentBeat) CursorExists() (bool, error) {
 }
 
 // Creates the elastic search indexes required for the cursor and the contracts
-// specified in the configuration file
+// specified in the configuration file, restores the index settings left by catch up mode
 func (m *DocumentBeat) configureIndexes() error {
 
 	log.Infof("Configuring indexes...")
@@ -246,9 +1046,48 @@ func (m *DocumentBeat) configureIndexes() error {
 			if err != nil {
 				return fmt.Errorf("failed creating index: %v for index: %v exists, error: %v", BaseIndex, index, err)
 			}
+		} else {
+			log.Infof("Index: %v exists, updating edge counts mappings...", index)
+			_, err = m.ElasticSearch.UpdateMappings(index, EdgeCountsMappings)
+			if err != nil {
+				return fmt.Errorf("failed updating mappings: %v for index: %v, error: %v", EdgeCountsMappings, index, err)
+			}
+		}
+		if m.Config.TrackFinality {
+			log.Infof("Finality tracking enabled, updating finality mappings for index: %v...", index)
+			_, err = m.ElasticSearch.UpdateMappings(index, FinalityMappings)
+			if err != nil {
+				return fmt.Errorf("failed updating mappings: %v for index: %v, error: %v", FinalityMappings, index, err)
+			}
+		}
+		if contract.EdgesIndex {
+			exists, err := m.IndexExists(contract.EdgesIndexName)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				log.Infof("Edges index: %v not exists, creating it...", contract.EdgesIndexName)
+				_, err = m.ElasticSearch.UpsertIndex(contract.EdgesIndexName, EdgesIndexConfig)
+				if err != nil {
+					return fmt.Errorf("failed creating edges index: %v, error: %v", contract.EdgesIndexName, err)
+				}
+			}
+		}
+		if m.Config.PendingEdges.Enabled {
+			exists, err := m.IndexExists(contract.PendingEdgesIndexName)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				log.Infof("Pending edges index: %v not exists, creating it...", contract.PendingEdgesIndexName)
+				_, err = m.ElasticSearch.UpsertIndex(contract.PendingEdgesIndexName, PendingEdgesIndexConfig)
+				if err != nil {
+					return fmt.Errorf("failed creating pending edges index: %v, error: %v", contract.PendingEdgesIndexName, err)
+				}
+			}
 		}
 	}
-	return nil
+	return m.CatchUp.Restore()
 }
 
 // func (m *DocumentBeat) configureIndexes() error {
@@ -281,28 +1120,46 @@ func (m *DocumentBeat) configureIndexes() error {
 // 	return nil
 // }
 
-// Returns the document with the specified id
+// Returns the document with the specified id, reflecting the writes held in the overlay
 func (m *DocumentBeat) GetDocument(docId, docIndex string, fields []string) (map[string]interface{}, error) {
 
-	exists, err := m.DocumentExists(docId, docIndex)
-	if err != nil {
-		return nil, err
+	if m.Overlay.IsScripted(docIndex, docId) {
+		err := m.Flush()
+		if err != nil {
+			return nil, err
+		}
 	}
-	if !exists {
-		log.Infof("Document: %v, index: %v  does not exist", docId, docIndex)
-		return nil, nil
+	entry := m.Overlay.get(docIndex, docId)
+	if entry != nil && !entry.partial {
+		if entry.deleted {
+			log.Infof("Document: %v, index: %v  is deleted in overlay", docId, docIndex)
+			return nil, nil
+		}
+		log.Infof("Getting document: %v, index: %v from overlay", docId, docIndex)
+		return filterFields(entry.doc, fields), nil
 	}
 	log.Infof("Getting document: %v, index: %v", docId, docIndex)
 	doc, err := m.ElasticSearch.Get(docIndex, docId, fields)
 	if err != nil {
 		return nil, fmt.Errorf("failed getting document, index: %v, id: %v, error: %v", docIndex, docId, err)
 	}
+	if doc == nil {
+		log.Infof("Document: %v, index: %v  does not exist", docId, docIndex)
+		return nil, nil
+	}
+	if entry != nil {
+		mergeDoc(doc, entry.doc)
+		doc = filterFields(doc, fields)
+	}
 	return doc, nil
 }
 
 // Checks whether the document with the specified id exists
 func (m *DocumentBeat) DocumentExists(docId, docIndex string) (bool, error) {
 	log.Infof("Checking if document: %v exists", docId)
+	if entry := m.Overlay.get(docIndex, docId); entry != nil && !entry.partial {
+		return !entry.deleted, nil
+	}
 	exists, err := m.ElasticSearch.DocumentExists(docIndex, docId)
 
 	if err != nil {
@@ -343,6 +1200,8 @@ func (m *DocumentBeat) DeleteIndex(index string) error {
 			return fmt.Errorf("failed deleting index: %v, error: %v", index, err)
 		}
 	}
+	m.Overlay.InvalidateIndex(index)
+	m.Metadata.Clear()
 	return nil
 }
 
@@ -403,6 +1262,65 @@ func (m *DocumentBeat) processField(value interface{}, name string, values map[s
 	singleTextField.AddValue(value, op)
 }
 
+// Returns the version type of a versioned write, the writes of the resumed block use external_gte
+// so that they are not rejected by the writes of the same block done before the restart
+func (m *DocumentBeat) versionType(version *int) string {
+	if version == nil {
+		return ""
+	}
+	if m.resumed {
+		return service.VersionType_ExternalGte
+	}
+	return service.VersionType_External
+}
+
+// Returns the property that holds the counts of the outgoing or incoming edges
+func edgeCountsProperty(property string) string {
+	if property == InEdgesPropertyName {
+		return InEdgeCountsPropertyName
+	}
+	return EdgeCountsPropertyName
+}
+
+// Sets the value of an edge or edge count in the document, the edge is removed if the value is nil
+func setEdgeProperty(doc map[string]interface{}, property, edgeName string, value interface{}) {
+	edges, ok := doc[property].(map[string]interface{})
+	if !ok {
+		edges = make(map[string]interface{})
+	}
+	if value == nil {
+		delete(edges, edgeName)
+	} else {
+		edges[edgeName] = value
+	}
+	if len(edges) == 0 {
+		delete(doc, property)
+	} else {
+		doc[property] = edges
+	}
+}
+
+// Returns the field used to match the values of a property exactly, text fields are matched
+// through their keyword subfield
+func keywordField(name string, mapping interface{}) string {
+	properties, _ := mapping.(map[string]interface{})
+	if properties["type"] == "text" {
+		if fields, ok := properties["fields"].(map[string]interface{}); ok && fields["keyword"] != nil {
+			return fmt.Sprintf("%v.keyword", name)
+		}
+	}
+	return name
+}
+
+func contains(hay []string, needle string) bool {
+	for _, value := range hay {
+		if value == needle {
+			return true
+		}
+	}
+	return false
+}
+
 func find(needle string, hay []interface{}) int {
 	for i, v := range hay {
 		if needle == v.(string) {
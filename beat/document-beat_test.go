@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
 	"github.com/sebastianmontero/document-graph-elasticsearch/config"
@@ -93,12 +94,14 @@ func setup(t *testing.T, cfg *config.Config) {
 		log.Fatal(err, "Failed creating elasticSearch client")
 	}
 	for _, contractConfig := range contractsConfig {
-		exists, err := elasticSearch.IndexExists(contractConfig.IndexName)
-		assert.NilError(t, err)
-
-		if exists {
-			_, err := elasticSearch.DeleteIndex(contractConfig.IndexName)
+		for _, index := range []string{contractConfig.IndexName, contractConfig.EdgesIndexName, contractConfig.PendingEdgesIndexName} {
+			exists, err := elasticSearch.IndexExists(index)
 			assert.NilError(t, err)
+
+			if exists {
+				_, err := elasticSearch.DeleteIndex(index)
+				assert.NilError(t, err)
+			}
 		}
 	}
 
@@ -273,7 +276,7 @@ func TestOpCycle(t *testing.T) {
 
 	t.Log("Adding period edge")
 	cursor = "cursor4_1"
-	err = docbeat.MutateEdge(domain.NewChainEdge("start.period", dhoId, period1Id), false, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("start.period", dhoId, period1Id), "", false, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	expectedDHODoc["edges"] = map[string]interface{}{
@@ -308,7 +311,7 @@ func TestOpCycle(t *testing.T) {
 
 	t.Log("Should skip edge for blacklisted Vote edge")
 	cursor = "cursor5_10"
-	err = docbeat.MutateEdge(domain.NewChainEdge("votes", dhoId, vote1Id), false, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("votes", dhoId, vote1Id), "", false, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	assertStoredDoc(t, expectedDHODoc, contract1Config.IndexName)
@@ -402,7 +405,7 @@ func TestOpCycle(t *testing.T) {
 
 	t.Log("Adding member edge")
 	cursor = "cursor5_1"
-	err = docbeat.MutateEdge(domain.NewChainEdge("member", dhoId, member1Id), false, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("member", dhoId, member1Id), "", false, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	expectedDHODoc["edges"].(map[string]interface{})["member"] = []interface{}{member1Id}
@@ -424,7 +427,7 @@ func TestOpCycle(t *testing.T) {
 
 	t.Log("Should skip edge for blacklisted memberof Dao User edge")
 	cursor = "cursor5_10"
-	err = docbeat.MutateEdge(domain.NewChainEdge("member.of", dhoId, daoUser1Id), false, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("member.of", dhoId, daoUser1Id), "", false, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	assertStoredDoc(t, expectedDHODoc, contract1Config.IndexName)
@@ -532,7 +535,7 @@ func TestOpCycle(t *testing.T) {
 
 	t.Log("Adding member2 edge")
 	cursor = "cursor5_4"
-	err = docbeat.MutateEdge(domain.NewChainEdge("member", dhoId, member2Id), false, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("member", dhoId, member2Id), "", false, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	expectedDHODoc["edges"].(map[string]interface{})["member"] = []interface{}{member1Id, member2Id}
@@ -542,7 +545,7 @@ func TestOpCycle(t *testing.T) {
 
 	t.Log("Should add edge for non blacklisted applicant.of Dao User edge")
 	cursor = "cursor5_10"
-	err = docbeat.MutateEdge(domain.NewChainEdge("applicant.of", dhoId, daoUser1Id), false, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("applicant.of", dhoId, daoUser1Id), "", false, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	expectedDHODoc["edges"].(map[string]interface{})["applicantOf"] = []interface{}{daoUser1Id}
@@ -552,7 +555,7 @@ func TestOpCycle(t *testing.T) {
 
 	t.Log("Deleting member2 edge")
 	cursor = "cursor5_5"
-	err = docbeat.MutateEdge(domain.NewChainEdge("member", dhoId, member2Id), true, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("member", dhoId, member2Id), "", true, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	expectedDHODoc["edges"].(map[string]interface{})["member"] = []interface{}{member1Id}
@@ -562,7 +565,7 @@ func TestOpCycle(t *testing.T) {
 
 	t.Log("Deleting period edge")
 	cursor = "cursor5_6"
-	err = docbeat.MutateEdge(domain.NewChainEdge("start.period", dhoId, period1Id), true, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("start.period", dhoId, period1Id), "", true, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	expectedDHODoc["edges"].(map[string]interface{})["startPeriod"] = []interface{}{}
@@ -572,7 +575,7 @@ func TestOpCycle(t *testing.T) {
 
 	t.Log("Deleting member1 edge")
 	cursor = "cursor5_7"
-	err = docbeat.MutateEdge(domain.NewChainEdge("member", dhoId, member1Id), true, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("member", dhoId, member1Id), "", true, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	expectedDHODoc["edges"].(map[string]interface{})["member"] = []interface{}{}
@@ -768,7 +771,7 @@ func TestShouldSkipEdgeProcessingWithoutToType(t *testing.T) {
 
 	t.Log("Adding edge with TO document not having a type")
 	cursor = "cursor5_1"
-	err = docbeat.MutateEdge(domain.NewChainEdge("member", dhoId, untyped1Id), false, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("member", dhoId, untyped1Id), "", false, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	assertStoredDoc(t, expectedDHODoc, contract1Config.IndexName)
@@ -919,7 +922,7 @@ func TestShouldSkipEdgeProcessingWithoutFromType(t *testing.T) {
 
 	t.Log("Adding edge with FROM document not having a type")
 	cursor = "cursor5_1"
-	err = docbeat.MutateEdge(domain.NewChainEdge("dho", untyped1Id, dhoId), false, cursor, contract1Config)
+	err = docbeat.MutateEdge(domain.NewChainEdge("dho", untyped1Id, dhoId), "", false, cursor, contract1Config)
 	assert.NilError(t, err)
 
 	assertStoredDoc(t, expectedDHODoc, contract1Config.IndexName)
@@ -984,6 +987,968 @@ func TestShouldSkipEdgeProcessingWithoutFromType(t *testing.T) {
 // 	assertSingleSearchTextFieldMappings(t, contract2Config.IndexName, true)
 // }
 
+func TestUndoJournal(t *testing.T) {
+
+	setup(t, getBaseConfig())
+	member1Id := "31"
+	member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+	member1Doc := getMemberDoc(member1IdI, "member1")
+	expectedMember1Doc := getMemberValues(member1IdI, "member1")
+	period1Id := "21"
+	period1IdI, _ := strconv.ParseUint(period1Id, 10, 64)
+	periodDoc := getPeriodDoc(period1IdI, 1)
+	expectedPeriodDoc := getPeriodValues(period1IdI, 1)
+
+	t.Log("Block 1: storing member and period documents")
+	assert.NilError(t, docbeat.BeginBlock(1, "block1"))
+	cursor := "cursor1"
+	err := docbeat.StoreDocument(member1Doc, cursor, contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(periodDoc, cursor, contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertStoredDoc(t, expectedPeriodDoc, contract1Config.IndexName)
+
+	t.Log("Block 2: adding edge and updating member document")
+	assert.NilError(t, docbeat.BeginBlock(2, "block2"))
+	cursor = "cursor2"
+	err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period1Id), "", false, cursor, contract1Config)
+	assert.NilError(t, err)
+	member1Updated := getMemberDoc(member1IdI, "member1up")
+	err = docbeat.StoreDocument(member1Updated, cursor, contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Updated := getMemberValues(member1IdI, "member1up")
+	expectedMember1Updated["edges"] = map[string]interface{}{
+		"period": []interface{}{period1Id},
+	}
+	assertStoredDoc(t, expectedMember1Updated, contract1Config.IndexName)
+
+	t.Log("Undoing block 2, should restore member document without edge")
+	cursor = "cursor2_undo"
+	undone, err := docbeat.UndoBlock(2, "block2", cursor)
+	assert.NilError(t, err)
+	assert.Assert(t, undone)
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertStoredDoc(t, expectedPeriodDoc, contract1Config.IndexName)
+	assertCursor(t, cursor)
+
+	t.Log("Undoing block 2 again, should be a no op")
+	undone, err = docbeat.UndoBlock(2, "block2", cursor)
+	assert.NilError(t, err)
+	assert.Assert(t, undone)
+
+	t.Log("Block 2 on the new fork: adding edge")
+	assert.NilError(t, docbeat.BeginBlock(2, "block2b"))
+	cursor = "cursor2b"
+	err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period1Id), "", false, cursor, contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period": []interface{}{period1Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+
+	t.Log("Block 3: removing edge and deleting member document")
+	assert.NilError(t, docbeat.BeginBlock(3, "block3"))
+	cursor = "cursor3"
+	err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period1Id), "", true, cursor, contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.DeleteDocument(member1Doc, cursor, contract1Config)
+	assert.NilError(t, err)
+	assertDocNotExists(t, member1Id, contract1Config.IndexName)
+
+	t.Log("Undoing block 3, should restore member document with its edge")
+	cursor = "cursor3_undo"
+	undone, err = docbeat.UndoBlock(3, "block3", cursor)
+	assert.NilError(t, err)
+	assert.Assert(t, undone)
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertCursor(t, cursor)
+
+	t.Log("Undoing block 2 of the new fork and block 1, should remove all documents")
+	undone, err = docbeat.UndoBlock(2, "block2b", cursor)
+	assert.NilError(t, err)
+	assert.Assert(t, undone)
+	delete(expectedMember1Doc, "edges")
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	undone, err = docbeat.UndoBlock(1, "block1", cursor)
+	assert.NilError(t, err)
+	assert.Assert(t, undone)
+	assertDocNotExists(t, member1Id, contract1Config.IndexName)
+	assertDocNotExists(t, period1Id, contract1Config.IndexName)
+
+	t.Log("Undoing unknown block, should not be handled")
+	undone, err = docbeat.UndoBlock(0, "block0", cursor)
+	assert.NilError(t, err)
+	assert.Assert(t, !undone)
+
+	t.Log("Block 1 on the new fork: storing member document")
+	assert.NilError(t, docbeat.BeginBlock(1, "block1b"))
+	cursor = "cursor1b"
+	err = docbeat.StoreDocument(member1Doc, cursor, contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertDocNotExists(t, period1Id, contract1Config.IndexName)
+	assertCursor(t, cursor)
+}
+
+func TestFinalityTracking(t *testing.T) {
+
+	cfg := getBaseConfig()
+	cfg.TrackFinality = true
+	setup(t, cfg)
+	member1Id := "31"
+	member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+	member1Doc := getMemberDoc(member1IdI, "member1")
+	expectedMember1Doc := getMemberValues(member1IdI, "member1")
+	expectedMember1Doc[beat.FinalityPropertyName] = beat.FinalityReversible
+	expectedMember1Doc[beat.BlockNumPropertyName] = 10
+	period1Id := "21"
+	period1IdI, _ := strconv.ParseUint(period1Id, 10, 64)
+	periodDoc := getPeriodDoc(period1IdI, 1)
+	expectedPeriodDoc := getPeriodValues(period1IdI, 1)
+	expectedPeriodDoc[beat.FinalityPropertyName] = beat.FinalityReversible
+	expectedPeriodDoc[beat.BlockNumPropertyName] = 11
+
+	t.Log("Storing documents in reversible blocks")
+	assert.NilError(t, docbeat.BeginBlock(10, "block10"))
+	cursor := "cursor10"
+	err := docbeat.StoreDocument(member1Doc, cursor, contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+
+	assert.NilError(t, docbeat.BeginBlock(11, "block11"))
+	cursor = "cursor11"
+	err = docbeat.StoreDocument(periodDoc, cursor, contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, expectedPeriodDoc, contract1Config.IndexName)
+
+	t.Log("Flipping block 10 to irreversible")
+	err = docbeat.Finality.Flip(10)
+	assert.NilError(t, err)
+	expectedMember1Doc[beat.FinalityPropertyName] = beat.FinalityIrreversible
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertStoredDoc(t, expectedPeriodDoc, contract1Config.IndexName)
+
+	t.Log("Marking block 11 as irreversible")
+	docbeat.MarkIrreversible(11)
+	for i := 0; i < 50 && docbeat.Finality.Flipped() < 11; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, docbeat.Finality.Flipped(), uint64(11))
+	expectedPeriodDoc[beat.FinalityPropertyName] = beat.FinalityIrreversible
+	assertStoredDoc(t, expectedPeriodDoc, contract1Config.IndexName)
+	assert.Equal(t, docbeat.Journal.Len(), 0)
+}
+
+func TestDeadLetters(t *testing.T) {
+
+	setup(t, getBaseConfig())
+	_, err := docbeat.ElasticSearch.DeleteIndex(contract1Config.DeadLetterIndexName)
+	if err != nil {
+		t.Logf("Dead letter index did not exist: %v", err)
+	}
+	deadLetters, err := docbeat.GetDeadLetters(contract1Config, 0, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(deadLetters), 0)
+
+	deadLetter1 := &beat.DeadLetter{
+		Contract:   "contract1",
+		TableName:  "documents",
+		Operation:  "OPERATION_INSERT",
+		PrimaryKey: "21",
+		NewData:    "{invalid",
+		BlockNum:   20,
+		BlockId:    "block20",
+		Cursor:     "cursor20",
+		ForkStep:   "STEP_NEW",
+		Error:      "error unmarshalling doc new data",
+	}
+	deadLetter2 := &beat.DeadLetter{
+		Contract:   "contract1",
+		TableName:  "edges",
+		Operation:  "OPERATION_REMOVE",
+		PrimaryKey: "22",
+		OldData:    "{invalid",
+		BlockNum:   10,
+		BlockId:    "block10",
+		Cursor:     "cursor10",
+		ForkStep:   "STEP_NEW",
+		Error:      "error unmarshalling edge data",
+	}
+	err = docbeat.StoreDeadLetter(deadLetter1, contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDeadLetter(deadLetter2, contract1Config)
+	assert.NilError(t, err)
+	t.Log("Storing the same dead letter twice should not create a duplicate")
+	err = docbeat.StoreDeadLetter(deadLetter2, contract1Config)
+	assert.NilError(t, err)
+
+	deadLetters, err = docbeat.GetDeadLetters(contract1Config, 0, 10)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, deadLetters, []*beat.DeadLetter{deadLetter2, deadLetter1})
+
+	deadLetters, err = docbeat.GetDeadLetters(contract1Config, 1, 10)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, deadLetters, []*beat.DeadLetter{deadLetter1})
+
+	err = docbeat.DeleteDeadLetter(deadLetter2, contract1Config)
+	assert.NilError(t, err)
+	deadLetters, err = docbeat.GetDeadLetters(contract1Config, 0, 10)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, deadLetters, []*beat.DeadLetter{deadLetter1})
+}
+
+func TestUpdateEdge(t *testing.T) {
+
+	setup(t, getBaseConfig())
+	member1Id := "31"
+	member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+	member1Doc := getMemberDoc(member1IdI, "member1")
+	expectedMember1Doc := getMemberValues(member1IdI, "member1")
+	period1Id := "21"
+	period1IdI, _ := strconv.ParseUint(period1Id, 10, 64)
+	period2Id := "22"
+	period2IdI, _ := strconv.ParseUint(period2Id, 10, 64)
+	vote1Id := "81"
+	vote1IdI, _ := strconv.ParseUint(vote1Id, 10, 64)
+
+	cursor := "cursor1"
+	err := docbeat.StoreDocument(member1Doc, cursor, contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getPeriodDoc(period1IdI, 1), cursor, contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getPeriodDoc(period2IdI, 2), cursor, contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getVoteDoc(vote1IdI, "vote1"), cursor, contract1Config)
+	assert.NilError(t, err)
+
+	cursor = "cursor2"
+	err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period1Id), "", false, cursor, contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period": []interface{}{period1Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+
+	t.Log("Updating edge TO document")
+	cursor = "cursor3"
+	err = docbeat.UpdateEdge(domain.NewChainEdge("period", member1Id, period1Id), domain.NewChainEdge("period", member1Id, period2Id), "", cursor, contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period": []interface{}{period2Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertCursor(t, cursor)
+
+	t.Log("Updating edge name")
+	cursor = "cursor4"
+	err = docbeat.UpdateEdge(domain.NewChainEdge("period", member1Id, period2Id), domain.NewChainEdge("start.period", member1Id, period2Id), "", cursor, contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period":      []interface{}{},
+		"startPeriod": []interface{}{period2Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertCursor(t, cursor)
+
+	t.Log("Updating edge without changing from, to or name should not modify the document")
+	cursor = "cursor5"
+	err = docbeat.UpdateEdge(domain.NewChainEdge("start.period", member1Id, period2Id), domain.NewChainEdge("start.period", member1Id, period2Id), "", cursor, contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertCursor(t, cursor)
+
+	t.Log("Updating edge to a black listed edge should only remove the old edge")
+	cursor = "cursor6"
+	err = docbeat.UpdateEdge(domain.NewChainEdge("start.period", member1Id, period2Id), domain.NewChainEdge("start.period", member1Id, vote1Id), "", cursor, contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period":      []interface{}{},
+		"startPeriod": []interface{}{},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertCursor(t, cursor)
+
+	t.Log("Updating black listed edge to a valid edge should only add the new edge")
+	cursor = "cursor7"
+	err = docbeat.UpdateEdge(domain.NewChainEdge("start.period", member1Id, vote1Id), domain.NewChainEdge("start.period", member1Id, period1Id), "", cursor, contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period":      []interface{}{},
+		"startPeriod": []interface{}{period1Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertCursor(t, cursor)
+}
+
+func TestInEdges(t *testing.T) {
+
+	cfg := getBaseConfig()
+	contract1Config.InEdges = true
+	setup(t, cfg)
+	member1Id := "41"
+	member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+	expectedMember1Doc := getMemberValues(member1IdI, "member1")
+	period1Id := "42"
+	period1IdI, _ := strconv.ParseUint(period1Id, 10, 64)
+	expectedPeriod1Doc := getPeriodValues(period1IdI, 1)
+	vote1Id := "43"
+	vote1IdI, _ := strconv.ParseUint(vote1Id, 10, 64)
+	expectedVote1Doc := getVoteValues(vote1IdI, "vote1")
+
+	assert.NilError(t, docbeat.BeginBlock(1, "block1"))
+	err := docbeat.StoreDocument(getMemberDoc(member1IdI, "member1"), "cursor1", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getPeriodDoc(period1IdI, 1), "cursor2", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getVoteDoc(vote1IdI, "vote1"), "cursor3", contract1Config)
+	assert.NilError(t, err)
+
+	t.Log("Creating an edge adds the FROM document to the incoming edge of the TO document")
+	err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period1Id), "", false, "cursor4", contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period": []interface{}{period1Id},
+	}
+	expectedPeriod1Doc["in_edges"] = map[string]interface{}{
+		"period": []interface{}{member1Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertStoredDoc(t, expectedPeriod1Doc, contract1Config.IndexName)
+
+	t.Log("Black listed edges are not added to the incoming edges")
+	err = docbeat.MutateEdge(domain.NewChainEdge("vote", member1Id, vote1Id), "", false, "cursor5", contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertStoredDoc(t, expectedVote1Doc, contract1Config.IndexName)
+
+	t.Log("Storing the TO document keeps its incoming edges")
+	err = docbeat.StoreDocument(getPeriodDoc(period1IdI, 1), "cursor6", contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, expectedPeriod1Doc, contract1Config.IndexName)
+
+	t.Log("Deleting an edge removes the FROM document from the incoming edge")
+	assert.NilError(t, docbeat.BeginBlock(2, "block2"))
+	err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period1Id), "", true, "cursor7", contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period": []interface{}{},
+	}
+	expectedPeriod1Doc["in_edges"] = map[string]interface{}{
+		"period": []interface{}{},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertStoredDoc(t, expectedPeriod1Doc, contract1Config.IndexName)
+
+	t.Log("Undoing the block restores the incoming edge")
+	undone, err := docbeat.UndoBlock(2, "block2", "cursor8")
+	assert.NilError(t, err)
+	assert.Assert(t, undone)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period": []interface{}{period1Id},
+	}
+	expectedPeriod1Doc["in_edges"] = map[string]interface{}{
+		"period": []interface{}{member1Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertStoredDoc(t, expectedPeriod1Doc, contract1Config.IndexName)
+
+	t.Log("Deleting the FROM document removes it from the incoming edges")
+	assert.NilError(t, docbeat.BeginBlock(3, "block3"))
+	err = docbeat.DeleteDocument(getMemberDoc(member1IdI, "member1"), "cursor9", contract1Config)
+	assert.NilError(t, err)
+	assertDocNotExists(t, member1Id, contract1Config.IndexName)
+	expectedPeriod1Doc["in_edges"] = map[string]interface{}{
+		"period": []interface{}{},
+	}
+	assertStoredDoc(t, expectedPeriod1Doc, contract1Config.IndexName)
+}
+
+func TestEdgesIndex(t *testing.T) {
+
+	cfg := getBaseConfig()
+	contract1Config.EdgesIndex = true
+	setup(t, cfg)
+	assertIndexExists(t, contract1Config.EdgesIndexName, true)
+	member1Id := "51"
+	member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+	period1Id := "52"
+	period1IdI, _ := strconv.ParseUint(period1Id, 10, 64)
+	vote1Id := "53"
+	vote1IdI, _ := strconv.ParseUint(vote1Id, 10, 64)
+
+	assert.NilError(t, docbeat.BeginBlock(1, "block1"))
+	err := docbeat.StoreDocument(getMemberDoc(member1IdI, "member1"), "cursor1", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getPeriodDoc(period1IdI, 1), "cursor2", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getVoteDoc(vote1IdI, "vote1"), "cursor3", contract1Config)
+	assert.NilError(t, err)
+
+	chainEdge, createdDate, err := beat.UnmarshalChainEdge([]byte(fmt.Sprintf(`{"edge_name": "start.period", "from_node": %v, "to_node": %v, "created_date": "2020-11-12T19:27:47.000"}`, member1Id, period1Id)))
+	assert.NilError(t, err)
+	assert.Equal(t, createdDate, "2020-11-12T19:27:47.000")
+	err = docbeat.MutateEdge(chainEdge, createdDate, false, "cursor4", contract1Config)
+	assert.NilError(t, err)
+	expectedEdgeDoc := map[string]interface{}{
+		"from":        member1Id,
+		"to":          period1Id,
+		"name":        "startPeriod",
+		"fromType":    "Member",
+		"toType":      "Period",
+		"createdDate": "2020-11-12T19:27:47.000Z",
+		"blockNum":    float64(1),
+	}
+	edgeDoc, err := docbeat.GetDocument(beat.EdgeId(chainEdge), contract1Config.EdgesIndexName, nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, edgeDoc, expectedEdgeDoc)
+
+	t.Log("Black listed edges are not stored in the edges index")
+	voteEdge := domain.NewChainEdge("vote", member1Id, vote1Id)
+	err = docbeat.MutateEdge(voteEdge, "", false, "cursor5", contract1Config)
+	assert.NilError(t, err)
+	assertDocNotExists(t, beat.EdgeId(voteEdge), contract1Config.EdgesIndexName)
+
+	t.Log("Deleting the edge removes it from the edges index")
+	assert.NilError(t, docbeat.BeginBlock(2, "block2"))
+	err = docbeat.MutateEdge(chainEdge, "", true, "cursor6", contract1Config)
+	assert.NilError(t, err)
+	assertDocNotExists(t, beat.EdgeId(chainEdge), contract1Config.EdgesIndexName)
+
+	t.Log("Undoing the block restores the edge")
+	undone, err := docbeat.UndoBlock(2, "block2", "cursor7")
+	assert.NilError(t, err)
+	assert.Assert(t, undone)
+	edgeDoc, err = docbeat.GetDocument(beat.EdgeId(chainEdge), contract1Config.EdgesIndexName, nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, edgeDoc, expectedEdgeDoc)
+}
+
+func TestPendingEdges(t *testing.T) {
+
+	cfg := getBaseConfig()
+	cfg.PendingEdges = config.PendingEdgesConfig{
+		Enabled:      true,
+		ExpiryBlocks: 10,
+	}
+	setup(t, cfg)
+	assertIndexExists(t, contract1Config.PendingEdgesIndexName, true)
+	member1Id := "61"
+	member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+	expectedMember1Doc := getMemberValues(member1IdI, "member1")
+	period1Id := "62"
+	period1IdI, _ := strconv.ParseUint(period1Id, 10, 64)
+	period2Id := "63"
+	period2IdI, _ := strconv.ParseUint(period2Id, 10, 64)
+
+	t.Log("Edges whose FROM document does not exist are queued")
+	assert.NilError(t, docbeat.BeginBlock(1, "block1"))
+	err := docbeat.StoreDocument(getPeriodDoc(period1IdI, 1), "cursor1", contract1Config)
+	assert.NilError(t, err)
+	period1Edge := domain.NewChainEdge("period", member1Id, period1Id)
+	err = docbeat.MutateEdge(period1Edge, "", false, "cursor2", contract1Config)
+	assert.NilError(t, err)
+	pendingEdges, err := docbeat.GetPendingEdges(member1Id, contract1Config)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pendingEdges, []*beat.PendingEdge{beat.NewPendingEdge(period1Edge, "", 1)})
+
+	t.Log("Edges whose TO document does not exist are queued after the FROM document is stored")
+	period2Edge := domain.NewChainEdge("period", member1Id, period2Id)
+	err = docbeat.MutateEdge(period2Edge, "", false, "cursor3", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getMemberDoc(member1IdI, "member1"), "cursor4", contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period": []interface{}{period1Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	pendingEdges, err = docbeat.GetPendingEdges(member1Id, contract1Config)
+	assert.NilError(t, err)
+	assert.Equal(t, len(pendingEdges), 0)
+	pendingEdges, err = docbeat.GetPendingEdges(period2Id, contract1Config)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pendingEdges, []*beat.PendingEdge{beat.NewPendingEdge(period2Edge, "", 1)})
+
+	t.Log("Storing the TO document applies the queued edge")
+	assert.NilError(t, docbeat.BeginBlock(2, "block2"))
+	err = docbeat.StoreDocument(getPeriodDoc(period2IdI, 2), "cursor5", contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period": []interface{}{period1Id, period2Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assertDocNotExists(t, period2Id, contract1Config.PendingEdgesIndexName)
+
+	t.Log("Undoing the block restores the queued edge")
+	undone, err := docbeat.UndoBlock(2, "block2", "cursor6")
+	assert.NilError(t, err)
+	assert.Assert(t, undone)
+	pendingEdges, err = docbeat.GetPendingEdges(period2Id, contract1Config)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pendingEdges, []*beat.PendingEdge{beat.NewPendingEdge(period2Edge, "", 1)})
+
+	t.Log("Deleting a queued edge removes it from the queue")
+	assert.NilError(t, docbeat.BeginBlock(3, "block3"))
+	err = docbeat.MutateEdge(period2Edge, "", true, "cursor7", contract1Config)
+	assert.NilError(t, err)
+	assertDocNotExists(t, period2Id, contract1Config.PendingEdgesIndexName)
+
+	t.Log("Queued edges expire after the expiry blocks")
+	err = docbeat.MutateEdge(period2Edge, "", false, "cursor8", contract1Config)
+	assert.NilError(t, err)
+	assert.NilError(t, docbeat.BeginBlock(14, "block14"))
+	pendingEdges, err = docbeat.GetPendingEdges(period2Id, contract1Config)
+	assert.NilError(t, err)
+	assert.Equal(t, len(pendingEdges), 0)
+	assert.NilError(t, docbeat.PurgePendingEdges(contract1Config))
+	assertDocNotExists(t, period2Id, contract1Config.PendingEdgesIndexName)
+}
+
+func TestCascadeDelete(t *testing.T) {
+
+	for _, inEdges := range []bool{false, true} {
+		cfg := getBaseConfig()
+		contract1Config.CascadeDelete = true
+		contract1Config.InEdges = inEdges
+		contract1Config.EdgesIndex = true
+		setup(t, cfg)
+		t.Logf("Cascade delete, in edges: %v", inEdges)
+		member1Id := "71"
+		member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+		member2Id := "72"
+		member2IdI, _ := strconv.ParseUint(member2Id, 10, 64)
+		period1Id := "73"
+		period1IdI, _ := strconv.ParseUint(period1Id, 10, 64)
+		period2Id := "74"
+		period2IdI, _ := strconv.ParseUint(period2Id, 10, 64)
+
+		assert.NilError(t, docbeat.BeginBlock(1, "block1"))
+		err := docbeat.StoreDocument(getMemberDoc(member1IdI, "member1"), "cursor1", contract1Config)
+		assert.NilError(t, err)
+		err = docbeat.StoreDocument(getMemberDoc(member2IdI, "member2"), "cursor2", contract1Config)
+		assert.NilError(t, err)
+		err = docbeat.StoreDocument(getPeriodDoc(period1IdI, 1), "cursor3", contract1Config)
+		assert.NilError(t, err)
+		err = docbeat.StoreDocument(getPeriodDoc(period2IdI, 2), "cursor4", contract1Config)
+		assert.NilError(t, err)
+		err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period1Id), "", false, "cursor5", contract1Config)
+		assert.NilError(t, err)
+		err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period2Id), "", false, "cursor6", contract1Config)
+		assert.NilError(t, err)
+		err = docbeat.MutateEdge(domain.NewChainEdge("start.period", member2Id, period1Id), "", false, "cursor7", contract1Config)
+		assert.NilError(t, err)
+
+		t.Log("Deleting a document removes it from the edges of the documents pointing to it")
+		assert.NilError(t, docbeat.BeginBlock(2, "block2"))
+		err = docbeat.DeleteDocument(getPeriodDoc(period1IdI, 1), "cursor8", contract1Config)
+		assert.NilError(t, err)
+		assertDocNotExists(t, period1Id, contract1Config.IndexName)
+		assertCursor(t, "cursor8")
+		member1Doc, err := docbeat.GetDocument(member1Id, contract1Config.IndexName, []string{"edges"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, member1Doc["edges"], map[string]interface{}{
+			"period": []interface{}{period2Id},
+		})
+		member2Doc, err := docbeat.GetDocument(member2Id, contract1Config.IndexName, []string{"edges"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, member2Doc["edges"], map[string]interface{}{
+			"startPeriod": []interface{}{},
+		})
+		assertDocNotExists(t, beat.EdgeId(domain.NewChainEdge("period", member1Id, period1Id)), contract1Config.EdgesIndexName)
+		assertDocNotExists(t, beat.EdgeId(domain.NewChainEdge("start.period", member2Id, period1Id)), contract1Config.EdgesIndexName)
+
+		t.Log("Undoing the block restores the document and the edges pointing to it")
+		undone, err := docbeat.UndoBlock(2, "block2", "cursor9")
+		assert.NilError(t, err)
+		assert.Assert(t, undone)
+		member1Doc, err = docbeat.GetDocument(member1Id, contract1Config.IndexName, []string{"edges"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, member1Doc["edges"], map[string]interface{}{
+			"period": []interface{}{period1Id, period2Id},
+		})
+		member2Doc, err = docbeat.GetDocument(member2Id, contract1Config.IndexName, []string{"edges"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, member2Doc["edges"], map[string]interface{}{
+			"startPeriod": []interface{}{period1Id},
+		})
+		exists, err := docbeat.DocumentExists(period1Id, contract1Config.IndexName)
+		assert.NilError(t, err)
+		assert.Assert(t, exists)
+	}
+}
+
+func TestEdgeCounts(t *testing.T) {
+
+	cfg := getBaseConfig()
+	contract1Config.InEdges = true
+	setup(t, cfg)
+	member1Id := "81"
+	member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+	member2Id := "82"
+	member2IdI, _ := strconv.ParseUint(member2Id, 10, 64)
+	dao1Id := "83"
+	dao1IdI, _ := strconv.ParseUint(dao1Id, 10, 64)
+	edgeCountsFields := []string{beat.EdgeCountsPropertyName, beat.InEdgeCountsPropertyName}
+
+	assert.NilError(t, docbeat.BeginBlock(1, "block1"))
+	err := docbeat.StoreDocument(getMemberDoc(member1IdI, "member1"), "cursor1", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getMemberDoc(member2IdI, "member2"), "cursor2", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getDaoUserDoc(dao1IdI, "dao1"), "cursor3", contract1Config)
+	assert.NilError(t, err)
+
+	t.Log("Creating edges increments the edge counts of the FROM and TO documents")
+	err = docbeat.MutateEdge(domain.NewChainEdge("member", dao1Id, member1Id), "", false, "cursor4", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.MutateEdge(domain.NewChainEdge("member", dao1Id, member2Id), "", false, "cursor5", contract1Config)
+	assert.NilError(t, err)
+	dao1Counts, err := docbeat.GetDocument(dao1Id, contract1Config.IndexName, edgeCountsFields)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, dao1Counts, map[string]interface{}{
+		beat.EdgeCountsPropertyName: map[string]interface{}{
+			"member": float64(2),
+		},
+	})
+	member1Counts, err := docbeat.GetDocument(member1Id, contract1Config.IndexName, edgeCountsFields)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, member1Counts, map[string]interface{}{
+		beat.InEdgeCountsPropertyName: map[string]interface{}{
+			"member": float64(1),
+		},
+	})
+
+	t.Log("Storing the document keeps its edge counts")
+	err = docbeat.StoreDocument(getDaoUserDoc(dao1IdI, "dao1"), "cursor6", contract1Config)
+	assert.NilError(t, err)
+	dao1Counts, err = docbeat.GetDocument(dao1Id, contract1Config.IndexName, edgeCountsFields)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, dao1Counts[beat.EdgeCountsPropertyName], map[string]interface{}{
+		"member": float64(2),
+	})
+
+	t.Log("Deleting an edge decrements the edge counts")
+	assert.NilError(t, docbeat.BeginBlock(2, "block2"))
+	err = docbeat.MutateEdge(domain.NewChainEdge("member", dao1Id, member1Id), "", true, "cursor7", contract1Config)
+	assert.NilError(t, err)
+	dao1Counts, err = docbeat.GetDocument(dao1Id, contract1Config.IndexName, edgeCountsFields)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, dao1Counts[beat.EdgeCountsPropertyName], map[string]interface{}{
+		"member": float64(1),
+	})
+	member1Counts, err = docbeat.GetDocument(member1Id, contract1Config.IndexName, edgeCountsFields)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, member1Counts[beat.InEdgeCountsPropertyName], map[string]interface{}{
+		"member": float64(0),
+	})
+
+	t.Log("Undoing the block restores the edge counts")
+	undone, err := docbeat.UndoBlock(2, "block2", "cursor8")
+	assert.NilError(t, err)
+	assert.Assert(t, undone)
+	dao1Counts, err = docbeat.GetDocument(dao1Id, contract1Config.IndexName, edgeCountsFields)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, dao1Counts[beat.EdgeCountsPropertyName], map[string]interface{}{
+		"member": float64(2),
+	})
+	member1Counts, err = docbeat.GetDocument(member1Id, contract1Config.IndexName, edgeCountsFields)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, member1Counts[beat.InEdgeCountsPropertyName], map[string]interface{}{
+		"member": float64(1),
+	})
+
+	t.Log("Edge counts are mapped as integers")
+	mappings, err := docbeat.ElasticSearch.GetMappings(contract1Config.IndexName)
+	assert.NilError(t, err)
+	properties := mappings["mappings"].(map[string]interface{})["properties"].(map[string]interface{})
+	edgeCounts := properties[beat.EdgeCountsPropertyName].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Equal(t, edgeCounts["member"].(map[string]interface{})["type"], "integer")
+}
+
+func TestProjections(t *testing.T) {
+
+	for _, inEdges := range []bool{false, true} {
+		cfg := getBaseConfig()
+		contract1Config.InEdges = inEdges
+		contract1Config.Projections = config.Projections{
+			{
+				Edge:   "ownedby",
+				Fields: []string{"details_account_n", "creator"},
+			},
+		}
+		contract1Config.ProjectionMaxPropagation = 1
+		setup(t, cfg)
+		t.Logf("Projections, in edges: %v", inEdges)
+		member1Id := "91"
+		member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+		period1Id := "92"
+		period1IdI, _ := strconv.ParseUint(period1Id, 10, 64)
+		period2Id := "93"
+		period2IdI, _ := strconv.ParseUint(period2Id, 10, 64)
+
+		assert.NilError(t, docbeat.BeginBlock(1, "block1"))
+		err := docbeat.StoreDocument(getMemberDoc(member1IdI, "member1"), "cursor1", contract1Config)
+		assert.NilError(t, err)
+		err = docbeat.StoreDocument(getPeriodDoc(period1IdI, 1), "cursor2", contract1Config)
+		assert.NilError(t, err)
+		err = docbeat.StoreDocument(getPeriodDoc(period2IdI, 2), "cursor3", contract1Config)
+		assert.NilError(t, err)
+
+		t.Log("Creating a projected edge copies the fields of the TO document to the FROM document")
+		err = docbeat.MutateEdge(domain.NewChainEdge("ownedby", period1Id, member1Id), "", false, "cursor4", contract1Config)
+		assert.NilError(t, err)
+		err = docbeat.MutateEdge(domain.NewChainEdge("ownedby", period2Id, member1Id), "", false, "cursor5", contract1Config)
+		assert.NilError(t, err)
+		linked := map[string]interface{}{
+			"ownedby": []interface{}{
+				map[string]interface{}{
+					"docId":             member1Id,
+					"details_account_n": "member1",
+					"creator":           "member1",
+				},
+			},
+		}
+		assertLinked(t, period1Id, linked)
+		assertLinked(t, period2Id, linked)
+
+		t.Log("Storing the TO document keeps its projection and propagates the changed fields, up to the max propagation")
+		err = docbeat.StoreDocument(getPeriodDoc(period1IdI, 1), "cursor6", contract1Config)
+		assert.NilError(t, err)
+		assertLinked(t, period1Id, linked)
+		assert.NilError(t, docbeat.BeginBlock(2, "block2"))
+		err = docbeat.StoreDocument(getMemberDoc(member1IdI, "member2"), "cursor7", contract1Config)
+		assert.NilError(t, err)
+		updated := 0
+		for _, periodId := range []string{period1Id, period2Id} {
+			doc, err := docbeat.GetDocument(periodId, contract1Config.IndexName, []string{beat.LinkedPropertyName})
+			assert.NilError(t, err)
+			entry := doc[beat.LinkedPropertyName].(map[string]interface{})["ownedby"].([]interface{})[0].(map[string]interface{})
+			if entry["details_account_n"] == "member2" {
+				assert.Equal(t, entry["creator"], "member2")
+				updated++
+			}
+		}
+		assert.Equal(t, updated, 1)
+
+		t.Log("Undoing the block restores the projected fields")
+		undone, err := docbeat.UndoBlock(2, "block2", "cursor8")
+		assert.NilError(t, err)
+		assert.Assert(t, undone)
+		assertLinked(t, period1Id, linked)
+		assertLinked(t, period2Id, linked)
+
+		t.Log("Deleting the edge removes the projected fields")
+		assert.NilError(t, docbeat.BeginBlock(3, "block3"))
+		err = docbeat.MutateEdge(domain.NewChainEdge("ownedby", period1Id, member1Id), "", true, "cursor9", contract1Config)
+		assert.NilError(t, err)
+		assertLinked(t, period1Id, map[string]interface{}{
+			"ownedby": []interface{}{},
+		})
+	}
+}
+
+func assertLinked(t *testing.T, docId string, expected map[string]interface{}) {
+	doc, err := docbeat.GetDocument(docId, contract1Config.IndexName, []string{beat.LinkedPropertyName})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, doc[beat.LinkedPropertyName], expected)
+}
+
+func TestBulkIndexing(t *testing.T) {
+
+	cfg := getBaseConfig()
+	cfg.Bulk = config.BulkConfig{
+		Enabled:       true,
+		MaxOperations: 100,
+		FlushInterval: time.Hour,
+	}
+	setup(t, cfg)
+	assert.NilError(t, docbeat.UpdateCursor("cursor0"))
+	member1Id := "41"
+	member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+	expectedMember1Doc := getMemberValues(member1IdI, "member1")
+	period1Id := "51"
+	period1IdI, _ := strconv.ParseUint(period1Id, 10, 64)
+
+	assert.NilError(t, docbeat.BeginBlock(1, "block1"))
+	err := docbeat.StoreDocument(getMemberDoc(member1IdI, "member1"), "cursor1", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getPeriodDoc(period1IdI, 1), "cursor2", contract1Config)
+	assert.NilError(t, err)
+
+	t.Log("Operations should not be executed until the batch is flushed")
+	assert.Equal(t, docbeat.Batch.Len(), 2)
+	exists, err := docbeat.ElasticSearch.DocumentExists(contract1Config.IndexName, member1Id)
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+	assertCursor(t, "cursor0")
+
+	t.Log("Documents with pending operations should be read from the overlay")
+	err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period1Id), "", false, "cursor3", contract1Config)
+	assert.NilError(t, err)
+	assert.Equal(t, docbeat.Batch.Len(), 3)
+	assertCursor(t, "cursor0")
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period": []interface{}{period1Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+
+	t.Log("Starting a new block should flush the batch")
+	assert.NilError(t, docbeat.BeginBlock(2, "block2"))
+	assert.Equal(t, docbeat.Batch.Len(), 0)
+	assertCursor(t, "cursor3")
+	actual, err := docbeat.ElasticSearch.Get(contract1Config.IndexName, member1Id, nil)
+	assert.NilError(t, err)
+	assertDoc(t, expectedMember1Doc, actual, nil)
+
+	err = docbeat.DeleteDocument(getPeriodDoc(period1IdI, 1), "cursor4", contract1Config)
+	assert.NilError(t, err)
+	assert.NilError(t, docbeat.Flush())
+	assertDocNotExists(t, period1Id, contract1Config.IndexName)
+	assertCursor(t, "cursor4")
+}
+
+func TestRefreshPolicyWithOverlay(t *testing.T) {
+
+	cfg := getBaseConfig()
+	cfg.Refresh = config.RefreshPolicy_False
+	setup(t, cfg)
+	member1Id := "61"
+	member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+	expectedMember1Doc := getMemberValues(member1IdI, "member1")
+	period1Id := "71"
+	period1IdI, _ := strconv.ParseUint(period1Id, 10, 64)
+	period2Id := "72"
+	period2IdI, _ := strconv.ParseUint(period2Id, 10, 64)
+
+	err := docbeat.StoreDocument(getMemberDoc(member1IdI, "member1"), "cursor1", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getPeriodDoc(period1IdI, 1), "cursor2", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.StoreDocument(getPeriodDoc(period2IdI, 2), "cursor3", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period1Id), "", false, "cursor4", contract1Config)
+	assert.NilError(t, err)
+	err = docbeat.MutateEdge(domain.NewChainEdge("period", member1Id, period2Id), "", false, "cursor5", contract1Config)
+	assert.NilError(t, err)
+	expectedMember1Doc["edges"] = map[string]interface{}{
+		"period": []interface{}{period1Id, period2Id},
+	}
+	assertStoredDoc(t, expectedMember1Doc, contract1Config.IndexName)
+	assert.Assert(t, docbeat.Overlay.Len() > 0)
+
+	err = docbeat.DeleteDocument(getPeriodDoc(period2IdI, 2), "cursor6", contract1Config)
+	assert.NilError(t, err)
+	assertDocNotExists(t, period2Id, contract1Config.IndexName)
+
+	actual, err := docbeat.ElasticSearch.Get(contract1Config.IndexName, member1Id, nil)
+	assert.NilError(t, err)
+	assertDoc(t, expectedMember1Doc, actual, nil)
+}
+
+func TestExternalVersioning(t *testing.T) {
+
+	cfg := getBaseConfig()
+	setup(t, cfg)
+	member1Id := "81"
+	member1IdI, _ := strconv.ParseUint(member1Id, 10, 64)
+
+	assert.NilError(t, docbeat.BeginBlock(11, "block11"))
+	err := docbeat.StoreDocument(getMemberDoc(member1IdI, "member1"), "cursor1", contract1Config)
+	assert.NilError(t, err)
+	assert.NilError(t, docbeat.BeginBlock(12, "block12"))
+	err = docbeat.StoreDocument(getMemberDoc(member1IdI, "member2"), "cursor2", contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, getMemberValues(member1IdI, "member2"), contract1Config.IndexName)
+
+	//Restart from an older cursor, replayed writes should be skipped
+	restarted, err := beat.NewDocumentBeat(docbeat.ElasticSearch, cfg, nil)
+	assert.NilError(t, err)
+	assert.NilError(t, restarted.BeginBlock(11, "block11"))
+	err = restarted.StoreDocument(getMemberDoc(member1IdI, "member1"), "cursor1", contract1Config)
+	assert.NilError(t, err)
+	err = restarted.DeleteDocument(getMemberDoc(member1IdI, "member1"), "cursor1", contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, getMemberValues(member1IdI, "member2"), contract1Config.IndexName)
+
+	assert.NilError(t, restarted.BeginBlock(13, "block13"))
+	err = restarted.StoreDocument(getMemberDoc(member1IdI, "member3"), "cursor3", contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, getMemberValues(member1IdI, "member3"), contract1Config.IndexName)
+
+	//Undone blocks are restored and the new fork writes are applied
+	assert.NilError(t, restarted.BeginBlock(14, "block14"))
+	err = restarted.StoreDocument(getMemberDoc(member1IdI, "member4"), "cursor4", contract1Config)
+	assert.NilError(t, err)
+	undone, err := restarted.UndoBlock(14, "block14", "cursor5")
+	assert.NilError(t, err)
+	assert.Assert(t, undone)
+	assertStoredDoc(t, getMemberValues(member1IdI, "member3"), contract1Config.IndexName)
+	assert.NilError(t, restarted.BeginBlock(14, "block14b"))
+	err = restarted.StoreDocument(getMemberDoc(member1IdI, "member5"), "cursor6", contract1Config)
+	assert.NilError(t, err)
+	assertStoredDoc(t, getMemberValues(member1IdI, "member5"), contract1Config.IndexName)
+}
+
+func TestCatchUpMode(t *testing.T) {
+
+	cfg := getBaseConfig()
+	cfg.Bulk = config.BulkConfig{
+		Enabled:       true,
+		MaxOperations: 10,
+		FlushInterval: time.Minute,
+	}
+	cfg.CatchUp = config.CatchUpConfig{
+		Enabled:       true,
+		EnterLag:      time.Hour,
+		ExitLag:       time.Minute,
+		MaxOperations: 100,
+	}
+	setup(t, cfg)
+	index := contract1Config.IndexName
+
+	assert.NilError(t, docbeat.CatchUp.Update(time.Now().Add(-30*time.Minute)))
+	assert.Assert(t, !docbeat.CatchUp.IsActive())
+
+	assert.NilError(t, docbeat.CatchUp.Update(time.Now().Add(-2*time.Hour)))
+	assert.Assert(t, docbeat.CatchUp.IsActive())
+	assert.Equal(t, docbeat.CatchUp.MaxOperations(cfg.Bulk.MaxOperations), uint(100))
+	settings, err := docbeat.ElasticSearch.GetSettings(index)
+	assert.NilError(t, err)
+	assert.Equal(t, settings["index.refresh_interval"], "-1")
+	assert.Equal(t, settings["index.number_of_replicas"], "0")
+
+	for i := uint64(1); i <= 20; i++ {
+		err = docbeat.StoreDocument(getMemberDoc(90+i, fmt.Sprintf("member%v", i)), fmt.Sprintf("cursor%v", i), contract1Config)
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, docbeat.Batch.Len(), 20)
+
+	//Still behind, catch up mode is kept
+	assert.NilError(t, docbeat.CatchUp.Update(time.Now().Add(-30*time.Minute)))
+	assert.Assert(t, docbeat.CatchUp.IsActive())
+
+	//Restarting while catching up restores the original settings
+	restarted, err := beat.NewDocumentBeat(docbeat.ElasticSearch, cfg, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, !restarted.CatchUp.IsActive())
+	settings, err = docbeat.ElasticSearch.GetSettings(index)
+	assert.NilError(t, err)
+	_, ok := settings["index.refresh_interval"]
+	assert.Assert(t, !ok)
+	assert.Equal(t, settings["index.number_of_replicas"], "1")
+
+	assert.NilError(t, restarted.CatchUp.Update(time.Now().Add(-2*time.Hour)))
+	assert.Assert(t, restarted.CatchUp.IsActive())
+	assert.NilError(t, restarted.CatchUp.Update(time.Now()))
+	assert.Assert(t, !restarted.CatchUp.IsActive())
+	assert.Equal(t, restarted.CatchUp.MaxOperations(cfg.Bulk.MaxOperations), uint(10))
+	settings, err = docbeat.ElasticSearch.GetSettings(index)
+	assert.NilError(t, err)
+	_, ok = settings["index.refresh_interval"]
+	assert.Assert(t, !ok)
+	assert.Equal(t, settings["index.number_of_replicas"], "1")
+}
+
 func TestToParsedDoc(t *testing.T) {
 
 	var err error
@@ -1258,7 +2223,27 @@ func TestToParsedDoc(t *testing.T) {
 func assertStoredDoc(t *testing.T, doc map[string]interface{}, docIndex string) {
 	d, err := docbeat.GetDocument(doc["docId"].(string), docIndex, nil)
 	assert.NilError(t, err)
-	assertDoc(t, doc, d, nil)
+	assertDoc(t, withEdgeCounts(doc), d, nil)
+}
+
+// Adds the edge counts matching the expected edges, unless they are explicitly expected
+func withEdgeCounts(doc map[string]interface{}) map[string]interface{} {
+	expected := make(map[string]interface{}, len(doc)+2)
+	for k, v := range doc {
+		expected[k] = v
+	}
+	for property, countProperty := range map[string]string{beat.EdgesPropertyName: beat.EdgeCountsPropertyName, beat.InEdgesPropertyName: beat.InEdgeCountsPropertyName} {
+		edges, ok := doc[property].(map[string]interface{})
+		if _, hasCounts := doc[countProperty]; !ok || hasCounts {
+			continue
+		}
+		counts := make(map[string]interface{}, len(edges))
+		for edgeName, values := range edges {
+			counts[edgeName] = len(values.([]interface{}))
+		}
+		expected[countProperty] = counts
+	}
+	return expected
 }
 
 func assertDoc(t *testing.T, expected, actual map[string]interface{}, valuesInSingleTextField []string) {
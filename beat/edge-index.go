@@ -0,0 +1,117 @@
+package beat
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/hypha-document-cache-gql-go/doccache/domain"
+)
+
+var (
+	EdgeFromPropertyName        = "from"
+	EdgeToPropertyName          = "to"
+	EdgeNamePropertyName        = "name"
+	EdgeFromTypePropertyName    = "fromType"
+	EdgeToTypePropertyName      = "toType"
+	EdgeCreatedDatePropertyName = "createdDate"
+	EdgesIndexConfig            = fmt.Sprintf(`
+		{
+			"mappings": {
+				"properties": {
+					"%v": {
+						"type": "keyword"
+					},
+					"%v": {
+						"type": "keyword"
+					},
+					"%v": {
+						"type": "keyword"
+					},
+					"%v": {
+						"type": "keyword"
+					},
+					"%v": {
+						"type": "keyword"
+					},
+					"%v": {
+						"type": "date"
+					},
+					"%v": {
+						"type": "long"
+					}
+				}
+			}
+		}
+	`, EdgeFromPropertyName, EdgeToPropertyName, EdgeNamePropertyName, EdgeFromTypePropertyName,
+		EdgeToTypePropertyName, EdgeCreatedDatePropertyName, BlockNumPropertyName)
+)
+
+// Chain edge along with its created date, which is not part of the domain chain edge and is
+// required to store the edge in the edges index
+type datedChainEdge struct {
+	*domain.ChainEdge
+	createdDate string
+}
+
+func newDatedChainEdge(chainEdge *domain.ChainEdge, createdDate string) *datedChainEdge {
+	return &datedChainEdge{
+		ChainEdge:   chainEdge,
+		createdDate: createdDate,
+	}
+}
+
+// UnmarshalChainEdge unmarshals an edge row, returns the chain edge along with its created date
+func UnmarshalChainEdge(data []byte) (*domain.ChainEdge, string, error) {
+	chainEdge := &domain.ChainEdge{}
+	err := json.Unmarshal(data, chainEdge)
+	if err != nil {
+		return nil, "", err
+	}
+	var row struct {
+		CreatedDate string `json:"created_date"`
+	}
+	err = json.Unmarshal(data, &row)
+	if err != nil {
+		return nil, "", err
+	}
+	return chainEdge, row.CreatedDate, nil
+}
+
+// EdgeId returns the id of the edge in the edges index
+func EdgeId(chainEdge *domain.ChainEdge) string {
+	return fmt.Sprintf("%v-%v-%v", chainEdge.From, chainEdge.DocEdgeName, chainEdge.To)
+}
+
+// Returns the edges index document for the edge
+func (m *datedChainEdge) toEdgeDoc(fromType, toType string, blockNum uint64) map[string]interface{} {
+	doc := map[string]interface{}{
+		EdgeFromPropertyName:     m.From,
+		EdgeToPropertyName:       m.To,
+		EdgeNamePropertyName:     m.DocEdgeName,
+		EdgeFromTypePropertyName: fromType,
+		EdgeToTypePropertyName:   toType,
+		BlockNumPropertyName:     blockNum,
+	}
+	if m.createdDate != "" {
+		doc[EdgeCreatedDatePropertyName] = domain.FormatDateTime(m.createdDate)
+	}
+	return doc
+}
+
+// Creates or deletes the edge document in the edges index of the contract, the prior state of
+// the edge document is recorded in the undo journal
+func (m *DocumentBeat) mutateEdgeDoc(chainEdge *datedChainEdge, fromType, toType string, deleteOp bool, contractConfig *config.ContractConfig) error {
+	index := contractConfig.EdgesIndexName
+	edgeId := EdgeId(chainEdge.ChainEdge)
+	err := m.journalDocument(edgeId, index)
+	if err != nil {
+		return err
+	}
+	if deleteOp {
+		log.Infof("Deleting edge: %v from edges index: %v", edgeId, index)
+		return m.delete(index, edgeId)
+	}
+	log.Infof("Storing edge: %v in edges index: %v", edgeId, index)
+	return m.upsert(index, edgeId, chainEdge.toEdgeDoc(fromType, toType, m.BlockNum))
+}
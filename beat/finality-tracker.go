@@ -0,0 +1,151 @@
+package beat
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/service"
+)
+
+var (
+	FinalityPropertyName = "finality"
+	BlockNumPropertyName = "blockNum"
+	FinalityReversible   = "reversible"
+	FinalityIrreversible = "irreversible"
+	FinalityMappings     = fmt.Sprintf(` {
+			"properties": {
+				"%v": {
+					"type": "keyword"
+				},
+				"%v": {
+					"type": "long"
+				}
+			}
+		}
+	`, FinalityPropertyName, BlockNumPropertyName)
+)
+
+// FinalityTracker flips documents from reversible to irreversible in the background, as the
+// stream reports blocks as irreversible
+type FinalityTracker struct {
+	elasticSearch *service.ElasticSearch
+	indexes       []string
+	lock          sync.Mutex
+	pending       uint64
+	flipped       uint64
+	stopped       bool
+	signal        chan struct{}
+	done          chan struct{}
+}
+
+// NewFinalityTracker creates a tracker for the specified indexes, Start has to be called for
+// the documents to be flipped
+func NewFinalityTracker(elasticSearch *service.ElasticSearch, indexes []string) *FinalityTracker {
+	return &FinalityTracker{
+		elasticSearch: elasticSearch,
+		indexes:       indexes,
+		signal:        make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+}
+
+// Starts the background process
+func (m *FinalityTracker) Start() {
+	go m.run()
+}
+
+// Stops the background process once the pending block has been processed, the blocks reported
+// after stopping are ignored
+func (m *FinalityTracker) Stop() {
+	m.lock.Lock()
+	if !m.stopped {
+		m.stopped = true
+		close(m.signal)
+	}
+	m.lock.Unlock()
+	<-m.done
+}
+
+// Indicates that all the blocks up to blockNum are irreversible, does not block, if several
+// blocks are reported while a flip is in progress only the highest one is processed
+func (m *FinalityTracker) MarkIrreversible(blockNum uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stopped {
+		return
+	}
+	if blockNum > m.pending {
+		m.pending = blockNum
+	}
+	select {
+	case m.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Returns the highest block for which documents have been flipped to irreversible
+func (m *FinalityTracker) Flipped() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.flipped
+}
+
+func (m *FinalityTracker) run() {
+	defer close(m.done)
+	for range m.signal {
+		m.lock.Lock()
+		blockNum := m.pending
+		flipped := m.flipped
+		m.lock.Unlock()
+		if blockNum <= flipped {
+			continue
+		}
+		err := m.Flip(blockNum)
+		if err != nil {
+			log.Errorf(err, "Failed flipping documents to irreversible up to block: %v, will retry on next irreversible block", blockNum)
+			continue
+		}
+		m.lock.Lock()
+		m.flipped = blockNum
+		m.lock.Unlock()
+	}
+}
+
+// Flips all the reversible documents with a block number lower or equal to blockNum to irreversible
+func (m *FinalityTracker) Flip(blockNum uint64) error {
+	body := map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": fmt.Sprintf("ctx._source.%v = params.finality", FinalityPropertyName),
+			"lang":   "painless",
+			"params": map[string]interface{}{
+				"finality": FinalityIrreversible,
+			},
+		},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{
+						"term": map[string]interface{}{
+							FinalityPropertyName: FinalityReversible,
+						},
+					},
+					map[string]interface{}{
+						"range": map[string]interface{}{
+							BlockNumPropertyName: map[string]interface{}{
+								"lte": blockNum,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, index := range m.indexes {
+		log.Debugf("Flipping documents to irreversible up to block: %v, index: %v", blockNum, index)
+		_, err := m.elasticSearch.UpdateByQuery(index, body)
+		if err != nil {
+			return fmt.Errorf("failed flipping documents to irreversible up to block: %v, index: %v, error: %v", blockNum, index, err)
+		}
+	}
+	return nil
+}
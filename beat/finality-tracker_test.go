@@ -0,0 +1,17 @@
+package beat_test
+
+import (
+	"testing"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
+	"gotest.tools/assert"
+)
+
+func TestFinalityTrackerIgnoresBlocksAfterStop(t *testing.T) {
+	tracker := beat.NewFinalityTracker(nil, nil)
+	tracker.Start()
+	tracker.Stop()
+	tracker.MarkIrreversible(10)
+	tracker.Stop()
+	assert.Equal(t, tracker.Flipped(), uint64(0))
+}
@@ -0,0 +1,153 @@
+package beat
+
+import (
+	"container/list"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/monitoring/metrics"
+)
+
+// DocMetadata holds the document properties required to process edges
+type DocMetadata struct {
+	DocId   string
+	Type    string
+	Edges   map[string]interface{}
+	InEdges map[string]interface{}
+}
+
+// Creates the metadata from a stored document
+func NewDocMetadata(doc map[string]interface{}) *DocMetadata {
+	metadata := &DocMetadata{
+		Edges:   make(map[string]interface{}),
+		InEdges: make(map[string]interface{}),
+	}
+	metadata.DocId, _ = doc["docId"].(string)
+	metadata.Type, _ = doc["type"].(string)
+	if edges, ok := doc[EdgesPropertyName].(map[string]interface{}); ok {
+		metadata.Edges = copyValue(edges).(map[string]interface{})
+	}
+	if inEdges, ok := doc[InEdgesPropertyName].(map[string]interface{}); ok {
+		metadata.InEdges = copyValue(inEdges).(map[string]interface{})
+	}
+	return metadata
+}
+
+// Returns the edge values, or an empty list if the document does not have the edge
+func (m *DocMetadata) Edge(edgeName string) []interface{} {
+	return edgeValues(m.Edges, edgeName)
+}
+
+// Returns the incoming edge values, or an empty list if the document does not have the incoming edge
+func (m *DocMetadata) InEdge(edgeName string) []interface{} {
+	return edgeValues(m.InEdges, edgeName)
+}
+
+// Returns the outgoing or incoming edges depending on the property
+func (m *DocMetadata) edges(property string) map[string]interface{} {
+	if property == InEdgesPropertyName {
+		return m.InEdges
+	}
+	return m.Edges
+}
+
+func (m *DocMetadata) copy() *DocMetadata {
+	return &DocMetadata{
+		DocId:   m.DocId,
+		Type:    m.Type,
+		Edges:   copyValue(m.Edges).(map[string]interface{}),
+		InEdges: copyValue(m.InEdges).(map[string]interface{}),
+	}
+}
+
+func edgeValues(edges map[string]interface{}, edgeName string) []interface{} {
+	if edge, ok := edges[edgeName].([]interface{}); ok {
+		return edge
+	}
+	return []interface{}{}
+}
+
+type metadataCacheEntry struct {
+	key      string
+	metadata *DocMetadata
+}
+
+// MetadataCache is a bounded LRU cache of document metadata, it avoids having to read the FROM
+// and TO documents from elastic search for every edge
+type MetadataCache struct {
+	size    int
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+// NewMetadataCache creates a cache that holds at most size documents
+func NewMetadataCache(size uint) *MetadataCache {
+	if size == 0 {
+		size = config.DefaultMetadataCacheSize
+	}
+	return &MetadataCache{
+		size:    int(size),
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Returns a copy of the document metadata, nil if the document is not in the cache
+func (m *MetadataCache) Get(index, docId string) *DocMetadata {
+	if element, ok := m.entries[overlayKey(index, docId)]; ok {
+		metrics.MetadataCacheHits.Inc()
+		m.ll.MoveToFront(element)
+		return element.Value.(*metadataCacheEntry).metadata.copy()
+	}
+	metrics.MetadataCacheMisses.Inc()
+	return nil
+}
+
+// Adds or replaces the document metadata, evicting the least recently used document if the cache is full
+func (m *MetadataCache) Put(index, docId string, metadata *DocMetadata) {
+	key := overlayKey(index, docId)
+	if element, ok := m.entries[key]; ok {
+		element.Value.(*metadataCacheEntry).metadata = metadata.copy()
+		m.ll.MoveToFront(element)
+		return
+	}
+	m.entries[key] = m.ll.PushFront(&metadataCacheEntry{
+		key:      key,
+		metadata: metadata.copy(),
+	})
+	if m.ll.Len() > m.size {
+		oldest := m.ll.Back()
+		m.ll.Remove(oldest)
+		delete(m.entries, oldest.Value.(*metadataCacheEntry).key)
+	}
+}
+
+// Sets the values of a document edge if the document is in the cache
+func (m *MetadataCache) SetEdge(index, docId, edgeName string, edge []interface{}) {
+	m.setEdge(index, docId, EdgesPropertyName, edgeName, edge)
+}
+
+func (m *MetadataCache) setEdge(index, docId, property, edgeName string, edge []interface{}) {
+	if element, ok := m.entries[overlayKey(index, docId)]; ok {
+		element.Value.(*metadataCacheEntry).metadata.edges(property)[edgeName] = copyValue(edge)
+	}
+}
+
+// Removes the document from the cache
+func (m *MetadataCache) Remove(index, docId string) {
+	key := overlayKey(index, docId)
+	if element, ok := m.entries[key]; ok {
+		m.ll.Remove(element)
+		delete(m.entries, key)
+	}
+}
+
+// Removes all the documents from the cache
+func (m *MetadataCache) Clear() {
+	m.ll.Init()
+	m.entries = make(map[string]*list.Element)
+}
+
+// Returns the number of documents in the cache
+func (m *MetadataCache) Len() int {
+	return m.ll.Len()
+}
@@ -0,0 +1,70 @@
+package beat_test
+
+import (
+	"testing"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
+	"gotest.tools/assert"
+)
+
+func TestMetadataCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := beat.NewMetadataCache(2)
+	cache.Put("index", "1", getMetadata("1", "member", nil))
+	cache.Put("index", "2", getMetadata("2", "role", nil))
+	assert.Assert(t, cache.Get("index", "1") != nil)
+
+	cache.Put("index", "3", getMetadata("3", "badge", nil))
+	assert.Equal(t, cache.Len(), 2)
+	assert.Assert(t, cache.Get("index", "2") == nil)
+	assert.Equal(t, cache.Get("index", "1").Type, "member")
+	assert.Equal(t, cache.Get("index", "3").Type, "badge")
+
+	cache.Remove("index", "1")
+	assert.Assert(t, cache.Get("index", "1") == nil)
+	cache.Clear()
+	assert.Equal(t, cache.Len(), 0)
+}
+
+func TestMetadataCacheReturnsCopies(t *testing.T) {
+	cache := beat.NewMetadataCache(10)
+	cache.Put("index", "1", getMetadata("1", "dho", map[string]interface{}{"member": []interface{}{"2"}}))
+
+	metadata := cache.Get("index", "1")
+	edge := append(metadata.Edge("member"), "3")
+	metadata.Edges["member"] = edge
+	assert.DeepEqual(t, cache.Get("index", "1").Edge("member"), []interface{}{"2"})
+
+	cache.SetEdge("index", "1", "member", edge)
+	cache.SetEdge("index", "4", "member", edge)
+	assert.DeepEqual(t, cache.Get("index", "1").Edge("member"), []interface{}{"2", "3"})
+	assert.DeepEqual(t, cache.Get("index", "1").Edge("role"), []interface{}{})
+	assert.Assert(t, cache.Get("index", "4") == nil)
+}
+
+func TestDocMetadataFromDocument(t *testing.T) {
+	metadata := beat.NewDocMetadata(map[string]interface{}{
+		"docId": "1",
+		"type":  "Member",
+		"edges": map[string]interface{}{
+			"member": []interface{}{"2"},
+		},
+	})
+	assert.Equal(t, metadata.DocId, "1")
+	assert.Equal(t, metadata.Type, "Member")
+	assert.DeepEqual(t, metadata.Edge("member"), []interface{}{"2"})
+
+	metadata = beat.NewDocMetadata(map[string]interface{}{"docId": "2"})
+	assert.Equal(t, metadata.Type, "")
+	assert.Equal(t, len(metadata.Edges), 0)
+}
+
+func getMetadata(docId, docType string, edges map[string]interface{}) *beat.DocMetadata {
+	if edges == nil {
+		edges = make(map[string]interface{})
+	}
+	return &beat.DocMetadata{
+		DocId: docId,
+		Type:  docType,
+		Edges: edges,
+	}
+}
@@ -0,0 +1,249 @@
+package beat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+)
+
+// Holds the latest known state of a written document
+type overlayEntry struct {
+	// Document, or when partial only the updated fields, in the form returned by elastic search
+	doc map[string]interface{}
+	// Indicates that doc only holds the updated fields, which have to be merged on top of the stored document
+	partial bool
+	// Indicates that the document was deleted
+	deleted bool
+	// Indicates that the write has not been sent to elastic search yet
+	pending bool
+	// Indicates that a pending scripted update has been sent for the document, its result is
+	// only known once it is flushed
+	scripted bool
+	written  time.Time
+}
+
+// Overlay holds the recently written documents until elastic search has refreshed them, so that
+// reads reflect all the previous writes without forcing a refresh on every write, it also holds the
+// documents with pending bulk operations
+type Overlay struct {
+	ttl        time.Duration
+	entries    map[string]*overlayEntry
+	lastExpire time.Time
+}
+
+// NewOverlay creates an overlay that keeps the written documents for ttl after they are sent to elastic search
+func NewOverlay(ttl time.Duration) *Overlay {
+	if ttl == 0 {
+		ttl = config.DefaultOverlayTTL
+	}
+	return &Overlay{
+		ttl:        ttl,
+		entries:    make(map[string]*overlayEntry),
+		lastExpire: time.Now(),
+	}
+}
+
+// Records a created or replaced document
+func (m *Overlay) Put(index, docId string, doc interface{}, pending bool) error {
+	normalized, err := normalizeDoc(doc)
+	if err != nil {
+		return fmt.Errorf("failed adding document: %v, index: %v to overlay, error: %v", docId, index, err)
+	}
+	m.set(index, docId, &overlayEntry{
+		doc:     normalized,
+		pending: pending,
+	})
+	return nil
+}
+
+// Records a partial update of a document, the update is merged with the known state of the document
+func (m *Overlay) Update(index, docId string, update interface{}, pending bool) error {
+	normalized, err := normalizeDoc(update)
+	if err != nil {
+		return fmt.Errorf("failed adding document update: %v, index: %v to overlay, error: %v", docId, index, err)
+	}
+	entry, ok := m.entries[overlayKey(index, docId)]
+	if !ok {
+		entry = &overlayEntry{
+			doc:     make(map[string]interface{}),
+			partial: true,
+		}
+	}
+	if !entry.deleted {
+		mergeDoc(entry.doc, normalized)
+	}
+	entry.pending = entry.pending || pending
+	m.set(index, docId, entry)
+	return nil
+}
+
+// Records a pending scripted update of a document, the resulting state of the document is not known
+// until the update is flushed
+func (m *Overlay) Script(index, docId string) {
+	entry, ok := m.entries[overlayKey(index, docId)]
+	if !ok {
+		entry = &overlayEntry{
+			doc:     make(map[string]interface{}),
+			partial: true,
+		}
+	}
+	entry.pending = true
+	entry.scripted = true
+	m.set(index, docId, entry)
+}
+
+// Returns whether the document has a pending scripted update
+func (m *Overlay) IsScripted(index, docId string) bool {
+	entry, ok := m.entries[overlayKey(index, docId)]
+	return ok && entry.scripted
+}
+
+// Records a deleted document
+func (m *Overlay) Delete(index, docId string, pending bool) {
+	m.set(index, docId, &overlayEntry{
+		deleted: true,
+		pending: pending,
+	})
+}
+
+// Indicates that the pending writes for the document have been sent to elastic search
+func (m *Overlay) Flushed(index, docId string) {
+	if entry, ok := m.entries[overlayKey(index, docId)]; ok {
+		entry.pending = false
+		entry.scripted = false
+		entry.written = time.Now()
+	}
+}
+
+// Removes the document from the overlay, used when a write failed so that the overlay does
+// not hold a state that is not stored
+func (m *Overlay) Invalidate(index, docId string) {
+	delete(m.entries, overlayKey(index, docId))
+}
+
+// Removes all the documents of the index from the overlay
+func (m *Overlay) InvalidateIndex(index string) {
+	prefix := overlayKey(index, "")
+	for key := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+// Returns the number of documents in the overlay
+func (m *Overlay) Len() int {
+	return len(m.entries)
+}
+
+func (m *Overlay) get(index, docId string) *overlayEntry {
+	return m.entries[overlayKey(index, docId)]
+}
+
+func (m *Overlay) set(index, docId string, entry *overlayEntry) {
+	entry.written = time.Now()
+	m.entries[overlayKey(index, docId)] = entry
+	m.expire()
+}
+
+// Removes the entries that have been sent to elastic search more than ttl ago
+func (m *Overlay) expire() {
+	now := time.Now()
+	if now.Sub(m.lastExpire) < m.ttl/2 {
+		return
+	}
+	for key, entry := range m.entries {
+		if !entry.pending && now.Sub(entry.written) > m.ttl {
+			delete(m.entries, key)
+		}
+	}
+	m.lastExpire = now
+}
+
+func overlayKey(index, docId string) string {
+	return fmt.Sprintf("%v/%v", index, docId)
+}
+
+// Converts the document to the form it would have when retrieved from elastic search
+func normalizeDoc(doc interface{}) (map[string]interface{}, error) {
+	marshalled, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var normalized map[string]interface{}
+	err = json.Unmarshal(marshalled, &normalized)
+	if err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// Merges the update into the doc following the elastic search partial update semantics, objects
+// are merged recursively and any other value is replaced
+func mergeDoc(doc, update map[string]interface{}) {
+	for key, value := range update {
+		if updateObj, ok := value.(map[string]interface{}); ok {
+			if docObj, ok := doc[key].(map[string]interface{}); ok {
+				mergeDoc(docObj, updateObj)
+				continue
+			}
+		}
+		doc[key] = copyValue(value)
+	}
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			c[key] = copyValue(value)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, value := range v {
+			c[i] = copyValue(value)
+		}
+		return c
+	default:
+		return value
+	}
+}
+
+// Returns a copy of the doc that only includes the specified fields, nested fields are specified
+// using dot notation, if no fields are specified all are included
+func filterFields(doc map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return copyValue(doc).(map[string]interface{})
+	}
+	filtered := make(map[string]interface{})
+	for _, field := range fields {
+		copyField(doc, filtered, strings.Split(field, "."))
+	}
+	return filtered
+}
+
+func copyField(from, to map[string]interface{}, path []string) {
+	value, ok := from[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		to[path[0]] = copyValue(value)
+		return
+	}
+	fromObj, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	toObj, ok := to[path[0]].(map[string]interface{})
+	if !ok {
+		toObj = make(map[string]interface{})
+		to[path[0]] = toObj
+	}
+	copyField(fromObj, toObj, path[1:])
+}
@@ -0,0 +1,44 @@
+package beat_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
+	"gotest.tools/assert"
+)
+
+func TestOverlayExpiresFlushedEntries(t *testing.T) {
+	overlay := beat.NewOverlay(20 * time.Millisecond)
+	assert.NilError(t, overlay.Put("index", "1", map[string]interface{}{"docId": "1"}, false))
+	assert.NilError(t, overlay.Update("index", "2", map[string]interface{}{"edges": map[string]interface{}{"member": []string{"1"}}}, true))
+	overlay.Delete("index", "3", false)
+	assert.Equal(t, overlay.Len(), 3)
+
+	time.Sleep(30 * time.Millisecond)
+	assert.NilError(t, overlay.Put("index", "4", map[string]interface{}{"docId": "4"}, true))
+	assert.Equal(t, overlay.Len(), 2)
+
+	overlay.Flushed("index", "2")
+	overlay.Flushed("index", "4")
+	time.Sleep(30 * time.Millisecond)
+	overlay.Delete("index", "5", true)
+	assert.Equal(t, overlay.Len(), 1)
+
+	overlay.InvalidateIndex("index")
+	assert.Equal(t, overlay.Len(), 0)
+}
+
+func TestOverlayTracksScriptedUpdates(t *testing.T) {
+	overlay := beat.NewOverlay(time.Minute)
+	overlay.Script("index", "1")
+	assert.Assert(t, overlay.IsScripted("index", "1"))
+	assert.Assert(t, !overlay.IsScripted("index", "2"))
+
+	overlay.Flushed("index", "1")
+	assert.Assert(t, !overlay.IsScripted("index", "1"))
+
+	overlay.Script("index", "2")
+	overlay.Invalidate("index", "2")
+	assert.Assert(t, !overlay.IsScripted("index", "2"))
+}
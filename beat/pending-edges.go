@@ -0,0 +1,274 @@
+package beat
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/monitoring/metrics"
+	"github.com/sebastianmontero/document-graph-elasticsearch/service"
+	"github.com/sebastianmontero/hypha-document-cache-gql-go/doccache/domain"
+)
+
+var (
+	PendingEdgesPropertyName      = "pendingEdges"
+	PendingEdgesCountPropertyName = "count"
+	PendingEdgesIndexConfig       = fmt.Sprintf(`
+		{
+			"mappings": {
+				"properties": {
+					"docId": {
+						"type": "keyword"
+					},
+					"%v": {
+						"type": "object",
+						"enabled": false
+					},
+					"%v": {
+						"type": "integer"
+					},
+					"%v": {
+						"type": "long"
+					}
+				}
+			}
+		}
+	`, PendingEdgesPropertyName, PendingEdgesCountPropertyName, BlockNumPropertyName)
+)
+
+// PendingEdge is an edge that could not be applied because its FROM or TO document did not exist,
+// it is stored in the pending edges index under the id of the missing document
+type PendingEdge struct {
+	Name        string `json:"name"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	CreatedDate string `json:"createdDate,omitempty"`
+	BlockNum    uint64 `json:"blockNum"`
+}
+
+// NewPendingEdge creates a pending edge for the chain edge queued at the specified block
+func NewPendingEdge(chainEdge *domain.ChainEdge, createdDate string, blockNum uint64) *PendingEdge {
+	return &PendingEdge{
+		Name:        chainEdge.Name,
+		From:        chainEdge.From,
+		To:          chainEdge.To,
+		CreatedDate: createdDate,
+		BlockNum:    blockNum,
+	}
+}
+
+// Returns the chain edge to apply once the missing document is stored
+func (m *PendingEdge) ChainEdge() *domain.ChainEdge {
+	return domain.NewChainEdge(m.Name, m.From, m.To)
+}
+
+// Returns whether the pending edge is the specified chain edge
+func (m *PendingEdge) Matches(chainEdge *domain.ChainEdge) bool {
+	return m.Name == chainEdge.Name && m.From == chainEdge.From && m.To == chainEdge.To
+}
+
+func (m *PendingEdge) String() string {
+	return fmt.Sprintf("PendingEdge{Name: %v, From: %v, To: %v, CreatedDate: %v, BlockNum: %v}", m.Name, m.From, m.To, m.CreatedDate, m.BlockNum)
+}
+
+// PendingEdges holds the edges waiting for a document to be stored, blockNum is the block at
+// which the newest edge was queued and is used to purge the expired documents
+type PendingEdges struct {
+	DocId    string         `json:"docId"`
+	Edges    []*PendingEdge `json:"pendingEdges"`
+	Count    int            `json:"count"`
+	BlockNum uint64         `json:"blockNum"`
+}
+
+// Returns the edges waiting for the specified document, the expired edges are dropped
+func (m *DocumentBeat) GetPendingEdges(docId string, contractConfig *config.ContractConfig) ([]*PendingEdge, error) {
+	doc, err := m.GetDocument(docId, contractConfig.PendingEdgesIndexName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting pending edges for document: %v, error: %v", docId, err)
+	}
+	if doc == nil {
+		return nil, nil
+	}
+	source, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshalling pending edges: %v, error: %v", doc, err)
+	}
+	pendingEdges := &PendingEdges{}
+	err = json.Unmarshal(source, pendingEdges)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling pending edges: %v, error: %v", string(source), err)
+	}
+	edges := make([]*PendingEdge, 0, len(pendingEdges.Edges))
+	for _, edge := range pendingEdges.Edges {
+		if m.isExpired(edge.BlockNum) {
+			log.Warnf("Pending edge: %v for document: %v expired, dropping it", edge, docId)
+			metrics.ExpiredPendingEdges.WithLabelValues(contractConfig.Name).Inc()
+			continue
+		}
+		edges = append(edges, edge)
+	}
+	return edges, nil
+}
+
+// Queues an edge until the missing document is stored, the prior state of the pending edges is
+// recorded in the undo journal
+func (m *DocumentBeat) queuePendingEdge(missingDocId string, pendingEdge *PendingEdge, contractConfig *config.ContractConfig) error {
+	edges, err := m.GetPendingEdges(missingDocId, contractConfig)
+	if err != nil {
+		return err
+	}
+	for _, edge := range edges {
+		if edge.Matches(pendingEdge.ChainEdge()) {
+			log.Infof("Edge: %v already waiting for document: %v, skipping", pendingEdge, missingDocId)
+			return nil
+		}
+	}
+	log.Infof("Queueing edge: %v until document: %v is stored", pendingEdge, missingDocId)
+	return m.storePendingEdges(missingDocId, append(edges, pendingEdge), contractConfig)
+}
+
+// Removes a queued edge, called when an edge is deleted before its missing document is stored
+func (m *DocumentBeat) removePendingEdge(missingDocId string, chainEdge *domain.ChainEdge, contractConfig *config.ContractConfig) error {
+	edges, err := m.GetPendingEdges(missingDocId, contractConfig)
+	if err != nil {
+		return err
+	}
+	remaining := make([]*PendingEdge, 0, len(edges))
+	for _, edge := range edges {
+		if !edge.Matches(chainEdge) {
+			remaining = append(remaining, edge)
+		}
+	}
+	if len(remaining) == len(edges) {
+		return nil
+	}
+	log.Infof("Removing edge: %v waiting for document: %v", chainEdge, missingDocId)
+	return m.storePendingEdges(missingDocId, remaining, contractConfig)
+}
+
+// Applies the edges that were waiting for the document, the edges whose other document does not
+// exist yet are queued again under the id of that document
+func (m *DocumentBeat) resolvePendingEdges(docId, cursor string, contractConfig *config.ContractConfig) error {
+	edges, err := m.GetPendingEdges(docId, contractConfig)
+	if err != nil {
+		return err
+	}
+	if len(edges) == 0 {
+		return nil
+	}
+	log.Infof("Resolving: %v edges waiting for document: %v", len(edges), docId)
+	err = m.storePendingEdges(docId, nil, contractConfig)
+	if err != nil {
+		return err
+	}
+	for _, edge := range edges {
+		missingDocId, err := m.applyEdge(newDatedChainEdge(edge.ChainEdge(), edge.CreatedDate), false, cursor, contractConfig)
+		if err != nil {
+			return fmt.Errorf("failed applying pending edge: %v, error: %v", edge, err)
+		}
+		if missingDocId != "" {
+			err = m.queuePendingEdge(missingDocId, edge, contractConfig)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		m.resolvedEdges++
+	}
+	return nil
+}
+
+// Stores the edges waiting for the document, the pending edges document is deleted if there are none
+func (m *DocumentBeat) storePendingEdges(docId string, edges []*PendingEdge, contractConfig *config.ContractConfig) error {
+	index := contractConfig.PendingEdgesIndexName
+	err := m.journalDocument(docId, index)
+	if err != nil {
+		return err
+	}
+	if len(edges) == 0 {
+		return m.delete(index, docId)
+	}
+	var blockNum uint64
+	for _, edge := range edges {
+		if edge.BlockNum > blockNum {
+			blockNum = edge.BlockNum
+		}
+	}
+	return m.upsert(index, docId, map[string]interface{}{
+		"docId":                       docId,
+		PendingEdgesPropertyName:      edges,
+		PendingEdgesCountPropertyName: len(edges),
+		BlockNumPropertyName:          blockNum,
+	})
+}
+
+// Deletes the pending edges documents of the contract whose newest edge has expired and updates the pending
+// edges metrics, the index is refreshed first so that the counts reflect the recent writes. The purge is done
+// at most every tenth of the expiry blocks
+func (m *DocumentBeat) PurgePendingEdges(contractConfig *config.ContractConfig) error {
+	expiryBlocks := m.Config.PendingEdges.ExpiryBlocks
+	if !m.Config.PendingEdges.Enabled || m.BlockNum < m.pendingEdgesPurge[contractConfig.Name] {
+		return nil
+	}
+	if m.pendingEdgesPurge == nil {
+		m.pendingEdgesPurge = make(map[string]uint64)
+	}
+	m.pendingEdgesPurge[contractConfig.Name] = m.BlockNum + expiryBlocks/10 + 1
+	var expiredBefore uint64
+	if m.BlockNum > expiryBlocks {
+		expiredBefore = m.BlockNum - expiryBlocks
+	}
+	expiredQuery := map[string]interface{}{
+		"range": map[string]interface{}{
+			BlockNumPropertyName: map[string]interface{}{
+				"lt": expiredBefore,
+			},
+		},
+	}
+	index := contractConfig.PendingEdgesIndexName
+	err := m.refresh(index)
+	if err != nil {
+		return fmt.Errorf("failed refreshing pending edges, index: %v, error: %v", index, err)
+	}
+	res, err := m.ElasticSearch.Search(index, map[string]interface{}{
+		"size": 0,
+		"aggs": map[string]interface{}{
+			"total": map[string]interface{}{
+				"sum": map[string]interface{}{
+					"field": PendingEdgesCountPropertyName,
+				},
+			},
+			"expired": map[string]interface{}{
+				"filter": expiredQuery,
+				"aggs": map[string]interface{}{
+					"count": map[string]interface{}{
+						"sum": map[string]interface{}{
+							"field": PendingEdgesCountPropertyName,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed counting pending edges, index: %v, error: %v", index, err)
+	}
+	total := service.AggregationValue(res, "total")
+	expired := service.AggregationValue(res, "expired", "count")
+	if expired > 0 {
+		log.Infof("Purging: %v expired pending edges, index: %v", expired, index)
+		_, err = m.ElasticSearch.DeleteByQuery(index, map[string]interface{}{
+			"query": expiredQuery,
+		})
+		if err != nil {
+			return fmt.Errorf("failed purging expired pending edges, index: %v, error: %v", index, err)
+		}
+		metrics.ExpiredPendingEdges.WithLabelValues(contractConfig.Name).Add(expired)
+	}
+	metrics.PendingEdges.WithLabelValues(contractConfig.Name).Set(total - expired)
+	return nil
+}
+
+func (m *DocumentBeat) isExpired(blockNum uint64) bool {
+	return blockNum+m.Config.PendingEdges.ExpiryBlocks < m.BlockNum
+}
@@ -0,0 +1,195 @@
+package beat
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/monitoring/metrics"
+	"github.com/sebastianmontero/document-graph-elasticsearch/service"
+)
+
+var LinkedPropertyName = "linked"
+
+// Copies the projected fields of the TO document to linked.<edge> on the FROM document, or removes them
+// if the edge is deleted
+func (m *DocumentBeat) mutateProjection(index, fromId, toId string, projection *config.Projection, deleteOp bool) error {
+	if deleteOp {
+		return m.setLinked(index, fromId, projection.Edge, toId, nil)
+	}
+	doc, err := m.GetDocument(toId, index, projection.Fields)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return nil
+	}
+	return m.setLinked(index, fromId, projection.Edge, toId, projectedEntry(toId, doc, projection))
+}
+
+// Updates the linked fields of the documents that link to the stored document, only the projections
+// whose fields changed are propagated and at most projection-max-propagation documents are updated per projection
+func (m *DocumentBeat) propagateProjections(docId string, prior, doc map[string]interface{}, contractConfig *config.ContractConfig) error {
+	index := contractConfig.IndexName
+	for _, projection := range contractConfig.Projections {
+		if !projectionChanged(projection, prior, doc) {
+			continue
+		}
+		linking, err := m.getLinkingDocIds(docId, projection, contractConfig)
+		if err != nil {
+			return fmt.Errorf("failed getting documents linking to: %v through: %v, error: %v", docId, projection.Edge, err)
+		}
+		if uint(len(linking)) > contractConfig.ProjectionMaxPropagation {
+			skipped := uint(len(linking)) - contractConfig.ProjectionMaxPropagation
+			log.Warnf("Document: %v has: %v documents linking to it through: %v, only updating the first: %v, skipped: %v documents, their linked fields stay out of date until the document changes again", docId, len(linking), projection.Edge, contractConfig.ProjectionMaxPropagation, skipped)
+			metrics.ProjectionPropagationsTruncated.WithLabelValues(contractConfig.Name).Inc()
+			linking = linking[:contractConfig.ProjectionMaxPropagation]
+		}
+		entry := projectedEntry(docId, doc, projection)
+		for _, fromId := range linking {
+			err = m.setLinked(index, fromId, projection.Edge, docId, entry)
+			if err != nil {
+				return fmt.Errorf("failed propagating projection: %v of document: %v to: %v, error: %v", projection.Edge, docId, fromId, err)
+			}
+		}
+		log.Infof("Propagated projection: %v of document: %v to: %v documents", projection.Edge, docId, len(linking))
+		metrics.ProjectionPropagations.WithLabelValues(contractConfig.Name).Add(float64(len(linking)))
+	}
+	return nil
+}
+
+// Returns the ids of the documents that link to the document through the projection edge, they are taken from
+// the incoming edges of the document if they are tracked, otherwise they are found by searching the linked fields
+// after refreshing the index, the ids are matched exactly and the results are paged using search_after
+func (m *DocumentBeat) getLinkingDocIds(docId string, projection *config.Projection, contractConfig *config.ContractConfig) ([]string, error) {
+	index := contractConfig.IndexName
+	if contractConfig.InEdges {
+		doc, err := m.getEdgeMetadata(docId, index)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		docIds := make([]string, 0)
+		for _, fromId := range doc.InEdge(projection.Edge) {
+			docIds = append(docIds, fmt.Sprintf("%v", fromId))
+		}
+		return docIds, nil
+	}
+	err := m.refresh(index)
+	if err != nil {
+		return nil, err
+	}
+	properties, err := m.mappedProperties(index)
+	if err != nil {
+		return nil, err
+	}
+	linkedField := keywordField(fmt.Sprintf("%v.%v.docId", LinkedPropertyName, projection.Edge), linkedDocIdMapping(properties, projection.Edge))
+	sortField := keywordField("docId", properties["docId"])
+	docIds := make([]string, 0)
+	var searchAfter interface{}
+	for {
+		query := map[string]interface{}{
+			"size":    ReferencingDocsPageSize,
+			"_source": false,
+			"query": map[string]interface{}{
+				"terms": map[string]interface{}{
+					linkedField: []string{docId},
+				},
+			},
+			"sort": []interface{}{
+				map[string]interface{}{sortField: "asc"},
+			},
+		}
+		if searchAfter != nil {
+			query["search_after"] = searchAfter
+		}
+		res, err := m.ElasticSearch.Search(index, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed searching documents linking to: %v, index: %v, error: %v", docId, index, err)
+		}
+		hits := service.Hits(res)
+		for _, hit := range hits {
+			docIds = append(docIds, hit["_id"].(string))
+		}
+		if len(hits) < ReferencingDocsPageSize {
+			return docIds, nil
+		}
+		searchAfter = hits[len(hits)-1]["sort"]
+	}
+}
+
+// Returns the mapping of linked.<edge>.docId, nil if it is not mapped
+func linkedDocIdMapping(properties map[string]interface{}, edgeName string) interface{} {
+	linked, _ := properties[LinkedPropertyName].(map[string]interface{})
+	linkedProperties, _ := linked["properties"].(map[string]interface{})
+	edge, _ := linkedProperties[edgeName].(map[string]interface{})
+	edgeProperties, _ := edge["properties"].(map[string]interface{})
+	return edgeProperties["docId"]
+}
+
+// Sets the entry of the linked document in linked.<edge> of the document, the entry is removed if nil,
+// the prior state of the document is recorded in the undo journal
+func (m *DocumentBeat) setLinked(index, docId, edgeName, linkedId string, entry map[string]interface{}) error {
+	doc, err := m.GetDocument(docId, index, []string{LinkedPropertyName})
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return nil
+	}
+	linked, _ := doc[LinkedPropertyName].(map[string]interface{})
+	values := edgeValues(linked, edgeName)
+	updated := make([]interface{}, 0, len(values)+1)
+	found := false
+	for _, value := range values {
+		if v, ok := value.(map[string]interface{}); ok && fmt.Sprintf("%v", v["docId"]) == linkedId {
+			found = true
+			if entry != nil {
+				updated = append(updated, entry)
+			}
+			continue
+		}
+		updated = append(updated, value)
+	}
+	if !found {
+		if entry == nil {
+			return nil
+		}
+		updated = append(updated, entry)
+	}
+	err = m.journalDocument(docId, index)
+	if err != nil {
+		return err
+	}
+	log.Infof("Updating document: %v with linked: %v, values: %v", docId, edgeName, updated)
+	return m.update(index, docId, map[string]interface{}{
+		LinkedPropertyName: map[string]interface{}{
+			edgeName: updated,
+		},
+	})
+}
+
+// Returns the linked entry for the document, with its id and projected fields
+func projectedEntry(docId string, doc map[string]interface{}, projection *config.Projection) map[string]interface{} {
+	entry := map[string]interface{}{
+		"docId": docId,
+	}
+	for _, field := range projection.Fields {
+		if value, ok := doc[field]; ok {
+			entry[field] = value
+		}
+	}
+	return entry
+}
+
+// Returns whether any of the projected fields changed, values are compared by their json representation
+// as the prior values are read from elastic search
+func projectionChanged(projection *config.Projection, prior, doc map[string]interface{}) bool {
+	for _, field := range projection.Fields {
+		before, _ := json.Marshal(prior[field])
+		after, _ := json.Marshal(doc[field])
+		if string(before) != string(after) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package beat
+
+import "fmt"
+
+var DefaultUndoJournalSize uint = 400
+
+// Stores the state a document or one of its edges had before it was first modified in a block,
+// a nil prior value indicates the document or edge did not exist. Property indicates whether the
+// edge is an outgoing or incoming edge
+type JournalEntry struct {
+	Index    string
+	DocId    string
+	Property string
+	EdgeName string
+	Prior    interface{}
+}
+
+// Returns whether the entry refers to a document edge instead of the whole document
+func (m *JournalEntry) IsEdge() bool {
+	return m.EdgeName != ""
+}
+
+func (m *JournalEntry) String() string {
+	return fmt.Sprintf("JournalEntry{Index: %v, DocId: %v, Property: %v, EdgeName: %v, Prior: %v}", m.Index, m.DocId, m.Property, m.EdgeName, m.Prior)
+}
+
+// Records the prior state of every document and edge modified while processing a block
+type BlockJournal struct {
+	BlockNum uint64
+	BlockId  string
+	Entries  []*JournalEntry
+	touched  map[string]bool
+}
+
+func NewBlockJournal(blockNum uint64, blockId string) *BlockJournal {
+	return &BlockJournal{
+		BlockNum: blockNum,
+		BlockId:  blockId,
+		Entries:  make([]*JournalEntry, 0),
+		touched:  make(map[string]bool),
+	}
+}
+
+// Records the entry only if the document or edge has not been touched before in the block,
+// so that the entry always holds the state before the block was applied
+func (m *BlockJournal) record(entry *JournalEntry) {
+	key := journalKey(entry.Index, entry.DocId, entry.Property, entry.EdgeName)
+	if m.touched[key] {
+		return
+	}
+	m.touched[key] = true
+	m.Entries = append(m.Entries, entry)
+}
+
+// Returns whether the document or outgoing edge has already been recorded in the block
+func (m *BlockJournal) hasTouched(index, docId, edgeName string) bool {
+	property := ""
+	if edgeName != "" {
+		property = EdgesPropertyName
+	}
+	return m.touched[journalKey(index, docId, property, edgeName)]
+}
+
+func journalKey(index, docId, property, edgeName string) string {
+	return fmt.Sprintf("%v/%v/%v/%v", index, docId, property, edgeName)
+}
+
+// UndoJournal keeps the block journals of the most recent reversible blocks, so that when
+// a block is undone the exact state before the block can be restored
+type UndoJournal struct {
+	size    uint
+	blocks  []*BlockJournal
+	current *BlockJournal
+}
+
+// NewUndoJournal creates a journal that keeps at most size blocks
+func NewUndoJournal(size uint) *UndoJournal {
+	if size == 0 {
+		size = DefaultUndoJournalSize
+	}
+	return &UndoJournal{
+		size:   size,
+		blocks: make([]*BlockJournal, 0),
+	}
+}
+
+// Sets the block to which following entries are recorded, a new block journal is started
+// if the block is different from the current one
+func (m *UndoJournal) Begin(blockNum uint64, blockId string) {
+	if m.current != nil && m.current.BlockId == blockId {
+		return
+	}
+	m.current = NewBlockJournal(blockNum, blockId)
+	m.blocks = append(m.blocks, m.current)
+	if uint(len(m.blocks)) > m.size {
+		m.blocks = m.blocks[len(m.blocks)-int(m.size):]
+	}
+}
+
+// Stops recording entries until a new block is started
+func (m *UndoJournal) End() {
+	m.current = nil
+}
+
+// Returns whether entries are being recorded
+func (m *UndoJournal) IsRecording() bool {
+	return m.current != nil
+}
+
+// Returns whether the document or edge already has an entry in the current block
+func (m *UndoJournal) HasTouched(index, docId, edgeName string) bool {
+	return m.current != nil && m.current.hasTouched(index, docId, edgeName)
+}
+
+// Records the prior state of a document in the current block
+func (m *UndoJournal) RecordDocument(index, docId string, prior map[string]interface{}) {
+	if m.current == nil {
+		return
+	}
+	entry := &JournalEntry{
+		Index: index,
+		DocId: docId,
+	}
+	if prior != nil {
+		entry.Prior = prior
+	}
+	m.current.record(entry)
+}
+
+// Records the prior state of a document edge in the current block
+func (m *UndoJournal) RecordEdge(index, docId, edgeName string, prior []interface{}) {
+	m.recordEdge(index, docId, EdgesPropertyName, edgeName, prior)
+}
+
+// Records the prior state of a document incoming edge in the current block
+func (m *UndoJournal) RecordInEdge(index, docId, edgeName string, prior []interface{}) {
+	m.recordEdge(index, docId, InEdgesPropertyName, edgeName, prior)
+}
+
+func (m *UndoJournal) recordEdge(index, docId, property, edgeName string, prior []interface{}) {
+	if m.current == nil {
+		return
+	}
+	entry := &JournalEntry{
+		Index:    index,
+		DocId:    docId,
+		Property: property,
+		EdgeName: edgeName,
+	}
+	if prior != nil {
+		entry.Prior = append([]interface{}{}, prior...)
+	}
+	m.current.record(entry)
+}
+
+// Removes and returns the journal for the specified block, returns nil if the block
+// is not in the journal
+func (m *UndoJournal) Pop(blockId string) *BlockJournal {
+	for i := len(m.blocks) - 1; i >= 0; i-- {
+		if m.blocks[i].BlockId == blockId {
+			block := m.blocks[i]
+			m.blocks = append(m.blocks[:i], m.blocks[i+1:]...)
+			if m.current == block {
+				m.current = nil
+			}
+			return block
+		}
+	}
+	return nil
+}
+
+// Removes the journals of all blocks up to blockNum, as they can no longer be undone
+func (m *UndoJournal) Prune(blockNum uint64) {
+	i := 0
+	for i < len(m.blocks) && m.blocks[i].BlockNum <= blockNum {
+		if m.current == m.blocks[i] {
+			m.current = nil
+		}
+		i++
+	}
+	m.blocks = m.blocks[i:]
+}
+
+// Returns the number of blocks in the journal
+func (m *UndoJournal) Len() int {
+	return len(m.blocks)
+}
@@ -0,0 +1,66 @@
+package beat_test
+
+import (
+	"testing"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
+	"gotest.tools/assert"
+)
+
+func TestUndoJournalRecordsFirstTouchOnly(t *testing.T) {
+	journal := beat.NewUndoJournal(2)
+	journal.RecordDocument("index", "1", nil)
+	assert.Assert(t, !journal.IsRecording())
+	assert.Equal(t, journal.Len(), 0)
+
+	journal.Begin(1, "block1")
+	assert.Assert(t, journal.IsRecording())
+	journal.RecordDocument("index", "1", nil)
+	journal.RecordDocument("index", "1", map[string]interface{}{"docId": "1"})
+	journal.RecordEdge("index", "1", "member", []interface{}{"2"})
+	journal.RecordEdge("index", "1", "member", nil)
+	journal.RecordInEdge("index", "1", "member", nil)
+	journal.RecordInEdge("index", "1", "member", []interface{}{"3"})
+	assert.Assert(t, journal.HasTouched("index", "1", ""))
+	assert.Assert(t, journal.HasTouched("index", "1", "member"))
+	assert.Assert(t, !journal.HasTouched("index", "2", ""))
+
+	journal.Begin(1, "block1")
+	assert.Equal(t, journal.Len(), 1)
+	block := journal.Pop("block1")
+	assert.Assert(t, block != nil)
+	assert.Equal(t, len(block.Entries), 3)
+	assert.Assert(t, block.Entries[0].Prior == nil)
+	assert.DeepEqual(t, block.Entries[1].Prior, []interface{}{"2"})
+	assert.Equal(t, block.Entries[1].Property, beat.EdgesPropertyName)
+	assert.Assert(t, block.Entries[2].Prior == nil)
+	assert.Equal(t, block.Entries[2].Property, beat.InEdgesPropertyName)
+	assert.Assert(t, !journal.IsRecording())
+	assert.Assert(t, journal.Pop("block1") == nil)
+}
+
+func TestUndoJournalIsBounded(t *testing.T) {
+	journal := beat.NewUndoJournal(2)
+	journal.Begin(1, "block1")
+	journal.Begin(2, "block2")
+	journal.Begin(3, "block3")
+	assert.Equal(t, journal.Len(), 2)
+	assert.Assert(t, journal.Pop("block1") == nil)
+	assert.Assert(t, journal.Pop("block2") != nil)
+	assert.Assert(t, journal.IsRecording())
+	journal.End()
+	assert.Assert(t, !journal.IsRecording())
+}
+
+func TestUndoJournalPrune(t *testing.T) {
+	journal := beat.NewUndoJournal(10)
+	journal.Begin(1, "block1")
+	journal.Begin(2, "block2")
+	journal.Begin(3, "block3")
+	journal.Prune(2)
+	assert.Equal(t, journal.Len(), 1)
+	assert.Assert(t, journal.IsRecording())
+	journal.Prune(3)
+	assert.Equal(t, journal.Len(), 0)
+	assert.Assert(t, !journal.IsRecording())
+}
@@ -2,6 +2,10 @@ package config
 
 import (
 	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/sebastianmontero/hypha-document-cache-gql-go/doccache/domain"
 	"github.com/spf13/viper"
@@ -15,16 +19,66 @@ var (
 	SingleTextSearchFieldOp_Replace SingleTextSearchFieldOp = "replace"
 	CursorIndex                                             = "cursor"
 	DocumentIndex                                           = "documents"
+	DeadLetterIndex                                         = "dead-letters"
+	EdgesIndex                                              = "edges"
+	PendingEdgesIndex                                       = "pending-edges"
+)
+
+type ErrorPolicyAction string
+
+var (
+	ErrorPolicyAction_Fail          ErrorPolicyAction = "fail"
+	ErrorPolicyAction_Retry         ErrorPolicyAction = "retry"
+	ErrorPolicyAction_Skip          ErrorPolicyAction = "skip"
+	DefaultMaxAttempts              uint              = 5
+	DefaultInitialBackoff                             = time.Second
+	DefaultMaxBackoff                                 = time.Minute
+	DefaultShutdownGracePeriod                        = 20 * time.Second
+	DefaultBulkMaxOperations        uint              = 1000
+	DefaultBulkFlushInterval                          = time.Second
+	DefaultOverlayTTL                                 = 5 * time.Second
+	DefaultMetadataCacheSize        uint              = 10000
+	DefaultWorkerQueueSize          uint              = 100
+	DefaultCatchUpEnterLag                            = time.Hour
+	DefaultCatchUpExitLag                             = 5 * time.Minute
+	DefaultCatchUpMaxOperations     uint              = 5000
+	DefaultPendingEdgesExpiry       uint64            = 7200
+	DefaultProjectionMaxPropagation uint              = 1000
+)
+
+type EdgeRuleAction string
+
+var (
+	EdgeRuleAction_Allow EdgeRuleAction = "allow"
+	EdgeRuleAction_Deny  EdgeRuleAction = "deny"
+)
+
+type RefreshPolicy string
+
+var (
+	RefreshPolicy_True    RefreshPolicy = "true"
+	RefreshPolicy_WaitFor RefreshPolicy = "wait_for"
+	RefreshPolicy_False   RefreshPolicy = "false"
 )
 
 // Stores a contract configuration
 type ContractConfig struct {
-	Name          string        `mapstructure:"name"`
-	DocTableName  string        `mapstructure:"doc-table-name"`
-	EdgeTableName string        `mapstructure:"edge-table-name"`
-	IndexPrefix   string        `mapstructure:"index-prefix"`
-	EdgeBlackList EdgeBlackList `mapstructure:"edge-black-list"`
-	IndexName     string
+	Name                     string        `mapstructure:"name"`
+	DocTableName             string        `mapstructure:"doc-table-name"`
+	EdgeTableName            string        `mapstructure:"edge-table-name"`
+	IndexPrefix              string        `mapstructure:"index-prefix"`
+	EdgeBlackList            EdgeBlackList `mapstructure:"edge-black-list"`
+	EdgeRules                EdgeRules     `mapstructure:"edge-rules"`
+	ErrorPolicy              ErrorPolicy   `mapstructure:"error-policy"`
+	InEdges                  bool          `mapstructure:"in-edges"`
+	EdgesIndex               bool          `mapstructure:"edges-index"`
+	CascadeDelete            bool          `mapstructure:"cascade-delete"`
+	Projections              Projections   `mapstructure:"projections"`
+	ProjectionMaxPropagation uint          `mapstructure:"projection-max-propagation"` // Linking documents past the limit keep out of date projected fields until the document changes again
+	IndexName                string
+	DeadLetterIndexName      string
+	EdgesIndexName           string
+	PendingEdgesIndexName    string
 }
 
 // Validates a contract configuration and generates full index names
@@ -34,6 +88,9 @@ func (m *ContractConfig) Init() error {
 		return err
 	}
 	m.IndexName = getIndexName(m.IndexPrefix, DocumentIndex)
+	m.DeadLetterIndexName = getIndexName(m.IndexPrefix, DeadLetterIndex)
+	m.EdgesIndexName = getIndexName(m.IndexPrefix, EdgesIndex)
+	m.PendingEdgesIndexName = getIndexName(m.IndexPrefix, PendingEdgesIndex)
 	return nil
 }
 
@@ -55,9 +112,30 @@ func (m *ContractConfig) Validate() error {
 	if m.IndexPrefix == "" {
 		return fmt.Errorf("contracts index-prefix property is required")
 	}
+	if err := m.ErrorPolicy.Validate(); err != nil {
+		return err
+	}
+	if err := m.EdgeRules.Validate(); err != nil {
+		return err
+	}
+	if err := m.Projections.Validate(); err != nil {
+		return err
+	}
+	if len(m.Projections) > 0 && m.ProjectionMaxPropagation == 0 {
+		m.ProjectionMaxPropagation = DefaultProjectionMaxPropagation
+	}
 	return m.EdgeBlackList.Validate()
 }
 
+// Checks whether an edge should be stored, the edge rules are evaluated in order and the first
+// matching rule decides, if none matches the edge is stored unless it is black listed
+func (m *ContractConfig) IsEdgeAllowed(from, to, name string) bool {
+	if rule := m.EdgeRules.Match(from, to, name); rule != nil {
+		return rule.Action == EdgeRuleAction_Allow
+	}
+	return !m.EdgeBlackList.IsBlackListed(from, to, name)
+}
+
 func (m *ContractConfig) String() string {
 	return fmt.Sprintf(
 		`
@@ -66,12 +144,32 @@ func (m *ContractConfig) String() string {
 				DocTableName: %v
 				IndexPrefix: %v
 				IndexName: %v
+				DeadLetterIndexName: %v
+				ErrorPolicy: %v
+				EdgeRules: %v
+				InEdges: %v
+				EdgesIndex: %v
+				EdgesIndexName: %v
+				CascadeDelete: %v
+				Projections: %v
+				ProjectionMaxPropagation: %v
+				PendingEdgesIndexName: %v
 			}
 		`,
 		m.Name,
 		m.DocTableName,
 		m.IndexPrefix,
 		m.IndexName,
+		m.DeadLetterIndexName,
+		&m.ErrorPolicy,
+		m.EdgeRules,
+		m.InEdges,
+		m.EdgesIndex,
+		m.EdgesIndexName,
+		m.CascadeDelete,
+		m.Projections,
+		m.ProjectionMaxPropagation,
+		m.PendingEdgesIndexName,
 	)
 }
 
@@ -84,6 +182,267 @@ func (m ContractsConfig) Get(contract string) *ContractConfig {
 	return nil
 }
 
+// Stores the retry configuration, defines the max attempts and the exponential backoff between them
+type RetryConfig struct {
+	MaxAttempts    uint          `mapstructure:"max-attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial-backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max-backoff"`
+}
+
+// Validates the retry configuration and sets the defaults for the missing properties
+func (m *RetryConfig) Validate() error {
+
+	if m.MaxAttempts == 0 {
+		m.MaxAttempts = DefaultMaxAttempts
+	}
+	if m.InitialBackoff == 0 {
+		m.InitialBackoff = DefaultInitialBackoff
+	}
+	if m.MaxBackoff == 0 {
+		m.MaxBackoff = DefaultMaxBackoff
+	}
+	if m.MaxBackoff < m.InitialBackoff {
+		return fmt.Errorf("max-backoff: %v should be greater or equal than initial-backoff: %v", m.MaxBackoff, m.InitialBackoff)
+	}
+	return nil
+}
+
+// Returns the time to wait before the next attempt, doubles for every failed attempt up to max backoff
+func (m *RetryConfig) Backoff(attempt uint) time.Duration {
+	backoff := m.InitialBackoff
+	for i := uint(1); i < attempt && backoff < m.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > m.MaxBackoff {
+		backoff = m.MaxBackoff
+	}
+	return backoff
+}
+
+// Returns the backoff for the attempt with a random jitter, the result is between half and the full backoff
+func (m *RetryConfig) JitteredBackoff(attempt uint) time.Duration {
+	backoff := m.Backoff(attempt)
+	half := backoff / 2
+	if half <= 0 {
+		return backoff
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (m *RetryConfig) String() string {
+	return fmt.Sprintf(
+		`
+		RetryConfig{
+			MaxAttempts: %v,
+			InitialBackoff: %v,
+			MaxBackoff: %v,
+		}
+		`,
+		m.MaxAttempts,
+		m.InitialBackoff,
+		m.MaxBackoff,
+	)
+}
+
+// Stores the error policy configuration, defines what to do when a delta fails to be processed
+type ErrorPolicy struct {
+	Action      ErrorPolicyAction `mapstructure:"action"`
+	RetryConfig `mapstructure:",squash"`
+}
+
+// Validates the error policy configuration and sets the defaults for the missing properties
+func (m *ErrorPolicy) Validate() error {
+
+	if m.Action == "" {
+		m.Action = ErrorPolicyAction_Fail
+	}
+	if m.Action != ErrorPolicyAction_Fail && m.Action != ErrorPolicyAction_Retry && m.Action != ErrorPolicyAction_Skip {
+		return fmt.Errorf("invalid error-policy action, valid values are: [fail, retry, skip] found: %v", m.Action)
+	}
+	if err := m.RetryConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid error-policy, error: %v", err)
+	}
+	return nil
+}
+
+func (m *ErrorPolicy) String() string {
+	return fmt.Sprintf(
+		`
+		ErrorPolicy{
+			Action: %v,
+			RetryConfig: %v,
+		}
+		`,
+		m.Action,
+		&m.RetryConfig,
+	)
+}
+
+// Stores the checkpoint policy, defines when the cursor is persisted, the conditions are combined so
+// the cursor is persisted as soon as any of them is met, if none is set the cursor is persisted after every delta.
+// The deltas processed since the last checkpoint are replayed on restart, which is safe as their processing is idempotent
+type CheckpointConfig struct {
+	Deltas        uint          `mapstructure:"deltas"`
+	Interval      time.Duration `mapstructure:"interval"`
+	BlockBoundary bool          `mapstructure:"block-boundary"`
+}
+
+// Returns whether the cursor should be persisted after every delta
+func (m *CheckpointConfig) IsEveryDelta() bool {
+	return m.Deltas <= 1 && m.Interval == 0 && !m.BlockBoundary
+}
+
+func (m *CheckpointConfig) String() string {
+	return fmt.Sprintf(
+		`
+		CheckpointConfig{
+			Deltas: %v,
+			Interval: %v,
+			BlockBoundary: %v,
+		}
+		`,
+		m.Deltas,
+		m.Interval,
+		m.BlockBoundary,
+	)
+}
+
+// Stores the bulk indexing configuration, when enabled the write operations are accumulated and
+// flushed in a single bulk request at block boundaries, or when the max operations or flush interval is reached
+type BulkConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	MaxOperations uint          `mapstructure:"max-operations"`
+	FlushInterval time.Duration `mapstructure:"flush-interval"`
+}
+
+// Validates the bulk configuration and sets the defaults for the missing properties
+func (m *BulkConfig) Validate() error {
+	if m.MaxOperations == 0 {
+		m.MaxOperations = DefaultBulkMaxOperations
+	}
+	if m.FlushInterval == 0 {
+		m.FlushInterval = DefaultBulkFlushInterval
+	}
+	return nil
+}
+
+func (m *BulkConfig) String() string {
+	return fmt.Sprintf(
+		`
+		BulkConfig{
+			Enabled: %v,
+			MaxOperations: %v,
+			FlushInterval: %v,
+		}
+		`,
+		m.Enabled,
+		m.MaxOperations,
+		m.FlushInterval,
+	)
+}
+
+// Configures the parallel processing of contracts, when enabled the deltas of each contract
+// are processed by a dedicated worker
+type WorkersConfig struct {
+	Enabled   bool `mapstructure:"enabled"`
+	QueueSize uint `mapstructure:"queue-size"`
+}
+
+// Validates the workers configuration and sets the defaults for the missing properties
+func (m *WorkersConfig) Validate() error {
+	if m.QueueSize == 0 {
+		m.QueueSize = DefaultWorkerQueueSize
+	}
+	return nil
+}
+
+func (m *WorkersConfig) String() string {
+	return fmt.Sprintf(
+		`
+		WorkersConfig{
+			Enabled: %v,
+			QueueSize: %v,
+		}
+		`,
+		m.Enabled,
+		m.QueueSize,
+	)
+}
+
+// Configures the catch up mode, when enabled and the processed blocks are more than enter-lag behind
+// the wall clock, the contract indexes are switched to a bulk load profile, refresh disabled and no replicas,
+// and the bulk batches are allowed to grow up to max-operations. The original settings are restored once
+// the processed blocks are within exit-lag of the wall clock
+type CatchUpConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	EnterLag      time.Duration `mapstructure:"enter-lag"`
+	ExitLag       time.Duration `mapstructure:"exit-lag"`
+	MaxOperations uint          `mapstructure:"max-operations"`
+}
+
+// Validates the catch up configuration and sets the defaults for the missing properties
+func (m *CatchUpConfig) Validate() error {
+	if m.EnterLag == 0 {
+		m.EnterLag = DefaultCatchUpEnterLag
+	}
+	if m.ExitLag == 0 {
+		m.ExitLag = DefaultCatchUpExitLag
+	}
+	if m.MaxOperations == 0 {
+		m.MaxOperations = DefaultCatchUpMaxOperations
+	}
+	if m.ExitLag >= m.EnterLag {
+		return fmt.Errorf("exit-lag: %v should be less than enter-lag: %v", m.ExitLag, m.EnterLag)
+	}
+	return nil
+}
+
+func (m *CatchUpConfig) String() string {
+	return fmt.Sprintf(
+		`
+		CatchUpConfig{
+			Enabled: %v,
+			EnterLag: %v,
+			ExitLag: %v,
+			MaxOperations: %v,
+		}
+		`,
+		m.Enabled,
+		m.EnterLag,
+		m.ExitLag,
+		m.MaxOperations,
+	)
+}
+
+// Configures the pending edges queue, when enabled the edges whose FROM or TO document does not exist yet
+// are stored in the <index-prefix>-pending-edges index and applied once the missing document is stored,
+// pending edges that are not resolved within expiry-blocks blocks are dropped
+type PendingEdgesConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	ExpiryBlocks uint64 `mapstructure:"expiry-blocks"`
+}
+
+// Validates the pending edges configuration and sets the defaults for the missing properties
+func (m *PendingEdgesConfig) Validate() error {
+	if m.ExpiryBlocks == 0 {
+		m.ExpiryBlocks = DefaultPendingEdgesExpiry
+	}
+	return nil
+}
+
+func (m *PendingEdgesConfig) String() string {
+	return fmt.Sprintf(
+		`
+		PendingEdgesConfig{
+			Enabled: %v,
+			ExpiryBlocks: %v,
+		}
+		`,
+		m.Enabled,
+		m.ExpiryBlocks,
+	)
+}
+
 // Stores the edge black list configuration
 type EdgeBlackListElement struct {
 	From string `mapstructure:"from"`
@@ -156,51 +515,212 @@ func (m EdgeBlackList) IsBlackListed(from, to, name string) bool {
 	return false
 }
 
-// type EdgeBlackListMap map[string]map[string]map[string]bool
+// Stores a projection, the fields of the documents linked through the edge are copied to
+// linked.<edge> on the documents that link to them, edge is the name under which the edge is stored
+type Projection struct {
+	Edge   string   `mapstructure:"edge"`
+	Fields []string `mapstructure:"fields"`
+}
+
+func (m *Projection) String() string {
+
+	return fmt.Sprintf(
+		`
+		Projection{
+			Edge: %v,
+			Fields: %v,
+		}
+		`,
+		m.Edge,
+		m.Fields,
+	)
+}
+
+// Validates the projection configuration
+func (m *Projection) Validate() error {
+
+	if m.Edge == "" {
+		return fmt.Errorf("projection 'edge' property is required, projection: %v", m)
+	}
+	if len(m.Fields) == 0 {
+		return fmt.Errorf("projection 'fields' property is required, projection: %v", m)
+	}
+	return nil
+}
+
+type Projections []*Projection
+
+// Validates all configured projections
+func (m Projections) Validate() error {
+
+	edges := make(map[string]bool, len(m))
+	for _, p := range m {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		if edges[p.Edge] {
+			return fmt.Errorf("projection for edge: %v was specified more than once", p.Edge)
+		}
+		edges[p.Edge] = true
+	}
+	return nil
+}
+
+// Returns the projection for the edge, nil if the edge is not projected
+func (m Projections) Get(edgeName string) *Projection {
+
+	for _, p := range m {
+		if p.Edge == edgeName {
+			return p
+		}
+	}
+	return nil
+}
+
+// Returns the fields projected by any of the projections
+func (m Projections) Fields() []string {
+
+	fields := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, p := range m {
+		for _, field := range p.Fields {
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// Stores an edge rule, from, to and name are glob patterns, where "*" matches any sequence of characters
+// and "?" any single character, or regular expressions when enclosed in slashes, e.g. "/^(Role|Badge)$/",
+// an empty pattern matches any value
+type EdgeRule struct {
+	Action   EdgeRuleAction `mapstructure:"action"`
+	From     string         `mapstructure:"from"`
+	To       string         `mapstructure:"to"`
+	Name     string         `mapstructure:"name"`
+	patterns []*regexp.Regexp
+}
+
+func (m *EdgeRule) String() string {
+
+	return fmt.Sprintf(
+		`
+		EdgeRule{
+			Action: %v,
+			From: %v,
+			To: %v,
+			Name: %v,
+		}
+		`,
+		m.Action,
+		m.From,
+		m.To,
+		m.Name,
+	)
+}
+
+// Validates the edge rule and compiles its patterns
+func (m *EdgeRule) Validate() error {
+
+	if m.Action != EdgeRuleAction_Allow && m.Action != EdgeRuleAction_Deny {
+		return fmt.Errorf("invalid edge rule action, valid values are: [allow, deny] found: %v, rule: %v", m.Action, m)
+	}
+	m.patterns = make([]*regexp.Regexp, 0, 3)
+	for _, pattern := range []string{m.From, m.To, m.Name} {
+		compiled, err := compilePattern(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid edge rule pattern: %v, rule: %v, error: %v", pattern, m, err)
+		}
+		m.patterns = append(m.patterns, compiled)
+	}
+	return nil
+}
+
+// Checks whether the edge matches the rule
+func (m *EdgeRule) Matches(from, to, name string) bool {
+	for i, value := range []string{from, to, name} {
+		if !m.patterns[i].MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
 
-// func (m EdgeBlackList) generateMap() EdgeBlackListMap {
-// 	eblm := make(EdgeBlackListMap, 0)
+// Compares the rule configuration, the compiled patterns are derived from it
+func (m *EdgeRule) Equal(other *EdgeRule) bool {
+	return m.Action == other.Action && m.From == other.From && m.To == other.To && m.Name == other.Name
+}
 
-// 	for _, e := range m {
-// 		if _, ok := eblm[e.Name]; !ok {
-// 			eblm[e.Name] = make(map[string]map[string]bool, 0)
-// 		}
-// 		mf := eblm[e.Name]
-// 		if _, ok := mf[e.From]; !ok {
-// 			mf[e.From] = make(map[string]bool, 0)
-// 		}
-// 		mt := mf[e.From]
-// 		mt[e.To] = true
-// 	}
-// 	return eblm
-// }
+type EdgeRules []*EdgeRule
 
-// func (m EdgeBlackList) IsBlackListed(from, to, name string) bool {
-// 	mf, ok := m[name]
+// Validates and compiles all configured edge rules
+func (m EdgeRules) Validate() error {
 
-// }
+	for _, r := range m {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-// func (m EdgeBlackList) isBlackListed(from, to, name string) bool {
-// 	mf, ok := m[name]
+// Returns the first rule that matches the edge, nil if none matches
+func (m EdgeRules) Match(from, to, name string) *EdgeRule {
 
-// }
+	for _, r := range m {
+		if r.Matches(from, to, name) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Compiles a glob pattern or a regular expression enclosed in slashes
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return regexp.Compile(pattern[1 : len(pattern)-1])
+	}
+	if pattern == "" {
+		pattern = "*"
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.Compile("^" + expr + "$")
+}
 
 // Loads, validates and stores the initial configuration
 type Config struct {
-	ContractsRaw          []*ContractConfig `mapstructure:"contracts"`
-	CursorIndexPrefix     string            `mapstructure:"cursor-index-prefix"`
-	FirehoseEndpoint      string            `mapstructure:"firehose-endpoint"`
-	DfuseApiKey           string            `mapstructure:"dfuse-api-key"`
-	DfuseAuthURL          string            `mapstructure:"dfuse-auth-url"`
-	EosEndpoint           string            `mapstructure:"eos-endpoint"`
-	ElasticEndpoint       string            `mapstructure:"elastic-endpoint"`
-	ElasticCA             string            `mapstructure:"elastic-ca"`
-	PrometheusPort        uint              `mapstructure:"prometheus-port"`
-	StartBlock            int64             `mapstructure:"start-block"`
-	HeartBeatFrequency    uint              `mapstructure:"heart-beat-frequency"`
-	Contracts             ContractsConfig   `mapstructure:"should-not-map-this"`
-	SingleTextSearchField map[string]string `mapstructure:"single-text-search-field"`
-	AddIntsAsStrings      bool              `mapstructure:"add-ints-as-strings"`
+	ContractsRaw          []*ContractConfig  `mapstructure:"contracts"`
+	CursorIndexPrefix     string             `mapstructure:"cursor-index-prefix"`
+	FirehoseEndpoint      string             `mapstructure:"firehose-endpoint"`
+	DfuseApiKey           string             `mapstructure:"dfuse-api-key"`
+	DfuseAuthURL          string             `mapstructure:"dfuse-auth-url"`
+	EosEndpoint           string             `mapstructure:"eos-endpoint"`
+	ElasticEndpoint       string             `mapstructure:"elastic-endpoint"`
+	ElasticCA             string             `mapstructure:"elastic-ca"`
+	PrometheusPort        uint               `mapstructure:"prometheus-port"`
+	StartBlock            int64              `mapstructure:"start-block"`
+	StopBlock             uint64             `mapstructure:"stop-block"`
+	HeartBeatFrequency    uint               `mapstructure:"heart-beat-frequency"`
+	Contracts             ContractsConfig    `mapstructure:"should-not-map-this"`
+	SingleTextSearchField map[string]string  `mapstructure:"single-text-search-field"`
+	AddIntsAsStrings      bool               `mapstructure:"add-ints-as-strings"`
+	UndoJournalSize       uint               `mapstructure:"undo-journal-size"`
+	TrackFinality         bool               `mapstructure:"track-finality"`
+	ShutdownGracePeriod   time.Duration      `mapstructure:"shutdown-grace-period"`
+	Reconnect             RetryConfig        `mapstructure:"reconnect"`
+	Checkpoint            CheckpointConfig   `mapstructure:"checkpoint"`
+	Bulk                  BulkConfig         `mapstructure:"bulk"`
+	Refresh               RefreshPolicy      `mapstructure:"refresh"`
+	OverlayTTL            time.Duration      `mapstructure:"overlay-ttl"`
+	MetadataCacheSize     uint               `mapstructure:"metadata-cache-size"`
+	Workers               WorkersConfig      `mapstructure:"workers"`
+	CatchUp               CatchUpConfig      `mapstructure:"catch-up"`
+	PendingEdges          PendingEdgesConfig `mapstructure:"pending-edges"`
 	ElasticUser           string
 	ElasticPassword       string
 	CursorIndexName       string
@@ -235,6 +755,36 @@ func LoadConfig(filePath string) (*Config, error) {
 	}
 
 	config.CursorIndexName = getIndexName(config.CursorIndexPrefix, CursorIndex)
+	if config.ShutdownGracePeriod == 0 {
+		config.ShutdownGracePeriod = DefaultShutdownGracePeriod
+	}
+	if err := config.Reconnect.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid reconnect configuration, error: %v", err)
+	}
+	if err := config.Bulk.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid bulk configuration, error: %v", err)
+	}
+	if config.Refresh == "" {
+		config.Refresh = RefreshPolicy_True
+	}
+	if config.Refresh != RefreshPolicy_True && config.Refresh != RefreshPolicy_WaitFor && config.Refresh != RefreshPolicy_False {
+		return nil, fmt.Errorf("invalid refresh configuration, valid values are: [true, wait_for, false] found: %v", config.Refresh)
+	}
+	if config.OverlayTTL == 0 {
+		config.OverlayTTL = DefaultOverlayTTL
+	}
+	if config.MetadataCacheSize == 0 {
+		config.MetadataCacheSize = DefaultMetadataCacheSize
+	}
+	if err := config.Workers.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid workers configuration, error: %v", err)
+	}
+	if err := config.CatchUp.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid catch-up configuration, error: %v", err)
+	}
+	if err := config.PendingEdges.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pending-edges configuration, error: %v", err)
+	}
 	return &config, nil
 }
 
@@ -308,6 +858,7 @@ func (m *Config) String() string {
 				ElasticCA: %v
 				PrometheusPort: %v
 				StartBlock: %v
+				StopBlock: %v
 				HeartBeatFrequency: %v
 				ElasticUser: %v
 				ElasticPassword: %v
@@ -315,6 +866,18 @@ func (m *Config) String() string {
 				SingleTextSearchField: %v
 				CursorIndexName: %v
 				DfuseAuthURL: %v
+				UndoJournalSize: %v
+				TrackFinality: %v
+				ShutdownGracePeriod: %v
+				Reconnect: %v
+				Checkpoint: %v
+				Bulk: %v
+				Refresh: %v
+				OverlayTTL: %v
+				MetadataCacheSize: %v
+				Workers: %v
+				CatchUp: %v
+				PendingEdges: %v
 
 			}
 		`,
@@ -327,6 +890,7 @@ func (m *Config) String() string {
 		m.ElasticCA,
 		m.PrometheusPort,
 		m.StartBlock,
+		m.StopBlock,
 		m.HeartBeatFrequency,
 		m.ElasticUser,
 		m.ElasticPassword,
@@ -334,5 +898,17 @@ func (m *Config) String() string {
 		m.SingleTextSearchField,
 		m.CursorIndexName,
 		m.DfuseAuthURL,
+		m.UndoJournalSize,
+		m.TrackFinality,
+		m.ShutdownGracePeriod,
+		&m.Reconnect,
+		&m.Checkpoint,
+		&m.Bulk,
+		m.Refresh,
+		m.OverlayTTL,
+		m.MetadataCacheSize,
+		&m.Workers,
+		&m.CatchUp,
+		&m.PendingEdges,
 	)
 }
@@ -3,12 +3,24 @@ package config_test
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/sebastianmontero/document-graph-elasticsearch/config"
 	"github.com/sebastianmontero/hypha-document-cache-gql-go/doccache/domain"
 	"gotest.tools/assert"
 )
 
+var defaultRetryConfig = config.RetryConfig{
+	MaxAttempts:    config.DefaultMaxAttempts,
+	InitialBackoff: config.DefaultInitialBackoff,
+	MaxBackoff:     config.DefaultMaxBackoff,
+}
+
+var defaultErrorPolicy = config.ErrorPolicy{
+	Action:      config.ErrorPolicyAction_Fail,
+	RetryConfig: defaultRetryConfig,
+}
+
 func TestValidConfig(t *testing.T) {
 	os.Setenv("ES_USER", "elastic")
 	os.Setenv("ES_PASSWORD", "password")
@@ -21,6 +33,7 @@ func TestValidConfig(t *testing.T) {
 	assert.Equal(t, cfg.ElasticCA, "certificates/ca/ca.crt")
 	assert.Equal(t, cfg.PrometheusPort, uint(2114))
 	assert.Equal(t, cfg.StartBlock, int64(149760151))
+	assert.Equal(t, cfg.StopBlock, uint64(149770151))
 	assert.Equal(t, cfg.HeartBeatFrequency, uint(100))
 	assert.Equal(t, cfg.ElasticUser, "elastic")
 	assert.Equal(t, cfg.ElasticPassword, "password")
@@ -28,13 +41,71 @@ func TestValidConfig(t *testing.T) {
 	assert.Equal(t, cfg.CursorIndexPrefix, "testnet1")
 	assert.Equal(t, cfg.CursorIndexName, "testnet1-cursor")
 	assert.Equal(t, cfg.AddIntsAsStrings, true)
+	assert.Equal(t, cfg.ShutdownGracePeriod, 45*time.Second)
+	assert.DeepEqual(t, cfg.Reconnect, config.RetryConfig{
+		MaxAttempts:    10,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     time.Minute,
+	})
+	assert.DeepEqual(t, cfg.Checkpoint, config.CheckpointConfig{
+		Deltas:        500,
+		Interval:      5 * time.Second,
+		BlockBoundary: true,
+	})
+	assert.Equal(t, cfg.Checkpoint.IsEveryDelta(), false)
+	assert.DeepEqual(t, cfg.Bulk, config.BulkConfig{
+		Enabled:       true,
+		MaxOperations: 2000,
+		FlushInterval: config.DefaultBulkFlushInterval,
+	})
+	assert.Equal(t, cfg.Refresh, config.RefreshPolicy_WaitFor)
+	assert.Equal(t, cfg.OverlayTTL, 10*time.Second)
+	assert.Equal(t, cfg.MetadataCacheSize, uint(500))
+	assert.DeepEqual(t, cfg.Workers, config.WorkersConfig{
+		Enabled:   true,
+		QueueSize: 50,
+	})
+	assert.DeepEqual(t, cfg.CatchUp, config.CatchUpConfig{
+		Enabled:       true,
+		EnterLag:      2 * time.Hour,
+		ExitLag:       config.DefaultCatchUpExitLag,
+		MaxOperations: 10000,
+	})
+	assert.DeepEqual(t, cfg.PendingEdges, config.PendingEdgesConfig{
+		Enabled:      true,
+		ExpiryBlocks: 1000,
+	})
 	expectedContracts := config.ContractsConfig{
 		"contract1": {
-			Name:          "contract1",
-			DocTableName:  "documents",
-			EdgeTableName: "edges",
-			IndexPrefix:   "index1",
-			IndexName:     "index1-documents",
+			Name:                  "contract1",
+			DocTableName:          "documents",
+			EdgeTableName:         "edges",
+			IndexPrefix:           "index1",
+			IndexName:             "index1-documents",
+			DeadLetterIndexName:   "index1-dead-letters",
+			EdgesIndexName:        "index1-edges",
+			PendingEdgesIndexName: "index1-pending-edges",
+			EdgesIndex:            true,
+			CascadeDelete:         true,
+			Projections: config.Projections{
+				{
+					Edge:   "ownedby",
+					Fields: []string{"details_account_n"},
+				},
+				{
+					Edge:   "assignee",
+					Fields: []string{"details_account_n", "details_title_s"},
+				},
+			},
+			ProjectionMaxPropagation: 200,
+			ErrorPolicy: config.ErrorPolicy{
+				Action: config.ErrorPolicyAction_Retry,
+				RetryConfig: config.RetryConfig{
+					MaxAttempts:    3,
+					InitialBackoff: 500 * time.Millisecond,
+					MaxBackoff:     10 * time.Second,
+				},
+			},
 			EdgeBlackList: config.EdgeBlackList{
 				{
 					From: "*",
@@ -54,11 +125,35 @@ func TestValidConfig(t *testing.T) {
 			},
 		},
 		"contract2": {
-			Name:          "contract2",
-			DocTableName:  "docs",
-			EdgeTableName: "edgs",
-			IndexPrefix:   "index2",
-			IndexName:     "index2-documents",
+			Name:                  "contract2",
+			DocTableName:          "docs",
+			EdgeTableName:         "edgs",
+			IndexPrefix:           "index2",
+			IndexName:             "index2-documents",
+			DeadLetterIndexName:   "index2-dead-letters",
+			EdgesIndexName:        "index2-edges",
+			PendingEdgesIndexName: "index2-pending-edges",
+			ErrorPolicy: config.ErrorPolicy{
+				Action:      config.ErrorPolicyAction_Skip,
+				RetryConfig: defaultRetryConfig,
+			},
+			InEdges: true,
+			EdgeRules: config.EdgeRules{
+				{
+					Action: config.EdgeRuleAction_Allow,
+					From:   "Member",
+					Name:   "vote*",
+				},
+				{
+					Action: config.EdgeRuleAction_Deny,
+					Name:   "vote*",
+				},
+				{
+					Action: config.EdgeRuleAction_Deny,
+					From:   "/^(Role|Badge)$/",
+					To:     "Da?",
+				},
+			},
 		},
 	}
 	assert.DeepEqual(t, expectedContracts, cfg.Contracts)
@@ -83,6 +178,34 @@ func TestValidConfig(t *testing.T) {
 	assert.Assert(t, !contractCfg.EdgeBlackList.IsBlackListed("Member", "Dao", "any"))
 	assert.Assert(t, !contractCfg.EdgeBlackList.IsBlackListed("Dao", "Member", "payed"))
 
+	assert.Assert(t, !contractCfg.IsEdgeAllowed("any", "Vote", "any"))
+	assert.Assert(t, contractCfg.IsEdgeAllowed("Member", "Dao", "any"))
+
+	assert.Equal(t, contractCfg.Projections.Get("assignee").Edge, "assignee")
+	assert.Assert(t, contractCfg.Projections.Get("member") == nil)
+	assert.DeepEqual(t, contractCfg.Projections.Fields(), []string{"details_account_n", "details_title_s"})
+
+	contract2Cfg := cfg.Contracts.Get("contract2")
+	assert.Assert(t, contract2Cfg.IsEdgeAllowed("Member", "Proposal", "vote"))
+	assert.Assert(t, contract2Cfg.IsEdgeAllowed("Member", "Proposal", "votetally"))
+	assert.Assert(t, !contract2Cfg.IsEdgeAllowed("Dao", "Proposal", "vote"))
+	assert.Assert(t, !contract2Cfg.IsEdgeAllowed("Dao", "Proposal", "votetally"))
+	assert.Assert(t, contract2Cfg.IsEdgeAllowed("Dao", "Proposal", "ownedby"))
+	assert.Assert(t, !contract2Cfg.IsEdgeAllowed("Role", "Dao", "ownedby"))
+	assert.Assert(t, !contract2Cfg.IsEdgeAllowed("Badge", "Dao", "ownedby"))
+	assert.Assert(t, contract2Cfg.IsEdgeAllowed("Badges", "Dao", "ownedby"))
+	assert.Assert(t, contract2Cfg.IsEdgeAllowed("Role", "Dho", "ownedby"))
+
+	assert.Equal(t, contractCfg.ErrorPolicy.Backoff(1), 500*time.Millisecond)
+	assert.Equal(t, contractCfg.ErrorPolicy.Backoff(2), time.Second)
+	assert.Equal(t, contractCfg.ErrorPolicy.Backoff(3), 2*time.Second)
+	assert.Equal(t, contractCfg.ErrorPolicy.Backoff(10), 10*time.Second)
+	for attempt := uint(1); attempt < 10; attempt++ {
+		backoff := contractCfg.ErrorPolicy.Backoff(attempt)
+		jittered := contractCfg.ErrorPolicy.JitteredBackoff(attempt)
+		assert.Assert(t, jittered >= backoff/2 && jittered <= backoff, "jittered backoff: %v out of range for backoff: %v", jittered, backoff)
+	}
+
 }
 
 func TestValidConfigNoSingleTextSearchFieldConfig(t *testing.T) {
@@ -103,20 +226,49 @@ func TestValidConfigNoSingleTextSearchFieldConfig(t *testing.T) {
 	assert.Equal(t, cfg.DfuseApiKey, "")
 	assert.Equal(t, cfg.CursorIndexPrefix, "testnet1")
 	assert.Equal(t, cfg.AddIntsAsStrings, false)
+	assert.Equal(t, cfg.ShutdownGracePeriod, config.DefaultShutdownGracePeriod)
+	assert.DeepEqual(t, cfg.Reconnect, defaultRetryConfig)
+	assert.Equal(t, cfg.Checkpoint.IsEveryDelta(), true)
+	assert.DeepEqual(t, cfg.Bulk, config.BulkConfig{
+		MaxOperations: config.DefaultBulkMaxOperations,
+		FlushInterval: config.DefaultBulkFlushInterval,
+	})
+	assert.Equal(t, cfg.Refresh, config.RefreshPolicy_True)
+	assert.Equal(t, cfg.OverlayTTL, config.DefaultOverlayTTL)
+	assert.Equal(t, cfg.MetadataCacheSize, config.DefaultMetadataCacheSize)
+	assert.DeepEqual(t, cfg.Workers, config.WorkersConfig{
+		QueueSize: config.DefaultWorkerQueueSize,
+	})
+	assert.DeepEqual(t, cfg.CatchUp, config.CatchUpConfig{
+		EnterLag:      config.DefaultCatchUpEnterLag,
+		ExitLag:       config.DefaultCatchUpExitLag,
+		MaxOperations: config.DefaultCatchUpMaxOperations,
+	})
+	assert.DeepEqual(t, cfg.PendingEdges, config.PendingEdgesConfig{
+		ExpiryBlocks: config.DefaultPendingEdgesExpiry,
+	})
 	expectedContracts := config.ContractsConfig{
 		"contract1": {
-			Name:          "contract1",
-			DocTableName:  "documents",
-			EdgeTableName: "edges",
-			IndexPrefix:   "index1",
-			IndexName:     "index1-documents",
+			Name:                  "contract1",
+			DocTableName:          "documents",
+			EdgeTableName:         "edges",
+			IndexPrefix:           "index1",
+			IndexName:             "index1-documents",
+			DeadLetterIndexName:   "index1-dead-letters",
+			EdgesIndexName:        "index1-edges",
+			PendingEdgesIndexName: "index1-pending-edges",
+			ErrorPolicy:           defaultErrorPolicy,
 		},
 		"contract2": {
-			Name:          "contract2",
-			DocTableName:  "docs",
-			EdgeTableName: "edgs",
-			IndexPrefix:   "index2",
-			IndexName:     "index2-documents",
+			Name:                  "contract2",
+			DocTableName:          "docs",
+			EdgeTableName:         "edgs",
+			IndexPrefix:           "index2",
+			IndexName:             "index2-documents",
+			DeadLetterIndexName:   "index2-dead-letters",
+			EdgesIndexName:        "index2-edges",
+			PendingEdgesIndexName: "index2-pending-edges",
+			ErrorPolicy:           defaultErrorPolicy,
 		},
 	}
 	assert.DeepEqual(t, expectedContracts, cfg.Contracts)
@@ -144,18 +296,26 @@ func TestValidConfigSingleTextSearchFieldAllNone(t *testing.T) {
 	assert.Equal(t, cfg.AddIntsAsStrings, false)
 	expectedContracts := config.ContractsConfig{
 		"contract1": {
-			Name:          "contract1",
-			DocTableName:  "documents",
-			EdgeTableName: "edges",
-			IndexPrefix:   "index1",
-			IndexName:     "index1-documents",
+			Name:                  "contract1",
+			DocTableName:          "documents",
+			EdgeTableName:         "edges",
+			IndexPrefix:           "index1",
+			IndexName:             "index1-documents",
+			DeadLetterIndexName:   "index1-dead-letters",
+			EdgesIndexName:        "index1-edges",
+			PendingEdgesIndexName: "index1-pending-edges",
+			ErrorPolicy:           defaultErrorPolicy,
 		},
 		"contract2": {
-			Name:          "contract2",
-			DocTableName:  "docs",
-			EdgeTableName: "edgs",
-			IndexPrefix:   "index2",
-			IndexName:     "index2-documents",
+			Name:                  "contract2",
+			DocTableName:          "docs",
+			EdgeTableName:         "edgs",
+			IndexPrefix:           "index2",
+			IndexName:             "index2-documents",
+			DeadLetterIndexName:   "index2-dead-letters",
+			EdgesIndexName:        "index2-edges",
+			PendingEdgesIndexName: "index2-pending-edges",
+			ErrorPolicy:           defaultErrorPolicy,
 		},
 	}
 	assert.DeepEqual(t, expectedContracts, cfg.Contracts)
@@ -204,3 +364,31 @@ func TestShouldFailForInvalidEdgeBlackList(t *testing.T) {
 	_, err := config.LoadConfig("./config-invalid-edge-black-list.yml")
 	assert.ErrorContains(t, err, "edge blacklist 'to' property is required, element")
 }
+
+func TestShouldFailForInvalidEdgeRuleAction(t *testing.T) {
+	os.Setenv("ES_USER", "elastic")
+	os.Setenv("ES_PASSWORD", "password")
+	_, err := config.LoadConfig("./config-invalid-edge-rule-action.yml")
+	assert.ErrorContains(t, err, "invalid edge rule action")
+}
+
+func TestShouldFailForInvalidEdgeRulePattern(t *testing.T) {
+	os.Setenv("ES_USER", "elastic")
+	os.Setenv("ES_PASSWORD", "password")
+	_, err := config.LoadConfig("./config-invalid-edge-rule-pattern.yml")
+	assert.ErrorContains(t, err, "invalid edge rule pattern")
+}
+
+func TestShouldFailForInvalidProjection(t *testing.T) {
+	os.Setenv("ES_USER", "elastic")
+	os.Setenv("ES_PASSWORD", "password")
+	_, err := config.LoadConfig("./config-invalid-projection.yml")
+	assert.ErrorContains(t, err, "projection 'fields' property is required")
+}
+
+func TestShouldFailForInvalidErrorPolicy(t *testing.T) {
+	os.Setenv("ES_USER", "elastic")
+	os.Setenv("ES_PASSWORD", "password")
+	_, err := config.LoadConfig("./config-invalid-error-policy.yml")
+	assert.ErrorContains(t, err, "invalid error-policy action")
+}
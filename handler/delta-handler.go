@@ -0,0 +1,616 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pbcodec "github.com/dfuse-io/dfuse-eosio/pb/dfuse/eosio/codec/v1"
+	"github.com/sebastianmontero/dfuse-firehose-client/dfclient"
+	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/monitoring/metrics"
+	"github.com/sebastianmontero/document-graph-elasticsearch/source"
+	"github.com/sebastianmontero/hypha-document-cache-gql-go/doccache/domain"
+	"github.com/sebastianmontero/slog-go/slog"
+	"github.com/streamingfast/bstream"
+	pbbstream "github.com/streamingfast/pbgo/dfuse/bstream/v1"
+)
+
+var log *slog.Log
+
+// DeltaHandler processes the table deltas provided by a delta source and calls the correct
+// DocumentBeat methods based on the delta type
+type DeltaHandler struct {
+	// Processes the operations indicated by the table deltas and updates elastic search to reflect these changes
+	DocumentBeat *beat.DocumentBeat
+	// Stores the initial configuration information
+	Config *config.Config
+	// Counts the operations processed by the handler
+	Stats DeltaStats
+	// Indicates where in the stream we are located
+	cursor string
+	// Ensures only one delta is processed at a time, and that stopping waits for the delta in process
+	lock sync.Mutex
+	// Indicates the handler has been stopped and no more deltas should be processed
+	stopped bool
+	// Closed when the handler starts stopping, so that the deltas being retried are aborted
+	stopping chan struct{}
+	// Ensures the stopping channel is closed only once
+	stopOnce sync.Once
+	// Error that stopped the processing of deltas, once set the cursor is no longer persisted
+	fatalErr error
+	// Receives the fatal error, so that the stream can be stopped
+	fatal chan error
+	// Guards the fatal error, as it is set from the contract workers
+	fatalLock sync.Mutex
+	// Number of consecutive stream failures, reset every time the stream makes progress
+	failures uint
+	// Last stream error
+	lastErr error
+	// Indicates the requested stream reached the stop block
+	completed bool
+	// Processes the deltas of each contract in parallel, nil if workers are not enabled
+	pool *WorkerPool
+	// Document beats used by the contract workers
+	workerBeats map[string]*beat.DocumentBeat
+}
+
+// NewDeltaHandler creates a handler that applies the deltas using the document beat
+func NewDeltaHandler(documentBeat *beat.DocumentBeat, config *config.Config, logConfig *slog.Config) *DeltaHandler {
+	log = slog.New(logConfig, "delta-handler")
+	deltaHandler := &DeltaHandler{
+		DocumentBeat: documentBeat,
+		Config:       config,
+		stopping:     make(chan struct{}),
+		fatal:        make(chan error, 1),
+	}
+	if config.Workers.Enabled {
+		deltaHandler.startWorkers()
+	}
+	return deltaHandler
+}
+
+// Creates a worker with its own document beat for each contract, the cursor is persisted
+// as the workers commit their deltas
+func (m *DeltaHandler) startWorkers() {
+	contracts := make([]string, 0, len(m.Config.Contracts))
+	for name := range m.Config.Contracts {
+		contracts = append(contracts, name)
+	}
+	m.pool = NewWorkerPool(contracts, m.Config.Workers.QueueSize, m.processJob, m.persistCursor)
+	m.workerBeats = make(map[string]*beat.DocumentBeat, len(contracts))
+	for contract, worker := range m.pool.Workers {
+		m.workerBeats[contract] = m.DocumentBeat.NewWorkerBeat(worker.Commit)
+	}
+	log.Infof("Starting workers for contracts: %v, queue size: %v", contracts, m.Config.Workers.QueueSize)
+	m.pool.Start()
+}
+
+// Stores the number of operations processed
+type DeltaStats struct {
+	CreatedDocs  uint64
+	DeletedDocs  uint64
+	CreatedEdges uint64
+	DeletedEdges uint64
+	UpdatedEdges uint64
+}
+
+func (m *DeltaStats) String() string {
+	return fmt.Sprintf(
+		`
+			Summary {
+				CreatedDocs: %v
+				DeletedDocs: %v
+				CreatedEdges: %v
+				DeletedEdges: %v
+				UpdatedEdges: %v
+			}
+		`,
+		m.CreatedDocs,
+		m.DeletedDocs,
+		m.CreatedEdges,
+		m.DeletedEdges,
+		m.UpdatedEdges,
+	)
+}
+
+// Returns the cursor of the last processed delta
+func (m *DeltaHandler) Cursor() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.cursor
+}
+
+// Called every time there is a table delta of interest, processes the delta and applies the
+// contract error policy if the processing fails, when workers are enabled the delta is dispatched
+// to the contract worker instead
+func (m *DeltaHandler) OnDelta(delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep) {
+	log.Debugf("On Delta: \nCursor: %v \nFork Step: %v \nDelta %v ", cursor, forkStep, delta)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stopped || m.Err() != nil {
+		log.Debugf("Handler stopped, ignoring delta, cursor: %v", cursor)
+		return
+	}
+	m.updateCatchUp(delta.Block)
+	if m.pool != nil {
+		err := m.pool.Dispatch(delta.Code, &Job{
+			Delta:    delta,
+			Cursor:   cursor,
+			ForkStep: forkStep,
+			BlockNum: uint64(delta.Block.Number),
+		})
+		if err != nil {
+			log.Panicf(err, "Failed dispatching delta: %v, cursor: %v, fork step: %v", delta, cursor, forkStep)
+		}
+	} else if !m.handleDelta(m.DocumentBeat, delta, cursor, forkStep) {
+		return
+	}
+	metrics.BlockNumber.Set(float64(delta.Block.Number))
+	m.cursor = cursor
+	m.onProgress()
+}
+
+// Processes the delta using the document beat, applying the contract error policy if the processing fails.
+// Returns false if the handler is stopped while the delta is being retried or the batch of the document beat fails,
+// in which case the delta is not processed. A batch failure is fatal, as its operations were dropped
+func (m *DeltaHandler) handleDelta(documentBeat *beat.DocumentBeat, delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep) bool {
+	contractConfig := m.Config.Contracts.Get(delta.Code)
+	var policy *config.ErrorPolicy
+	if contractConfig != nil {
+		policy = &contractConfig.ErrorPolicy
+	} else {
+		policy = &config.ErrorPolicy{Action: config.ErrorPolicyAction_Fail}
+	}
+	var err error
+	for attempt := uint(1); ; attempt++ {
+		err = m.processDelta(documentBeat, delta, cursor, forkStep)
+		if documentBeat.Batch != nil && documentBeat.Batch.Err() != nil {
+			m.fail(fmt.Errorf("failed processing delta: %v, cursor: %v, error: %v", delta, cursor, documentBeat.Batch.Err()))
+			return false
+		}
+		if err == nil || policy.Action != config.ErrorPolicyAction_Retry || isPermanent(err) || attempt >= policy.MaxAttempts {
+			break
+		}
+		backoff := policy.Backoff(attempt)
+		log.Warnf("Failed processing delta, attempt: %v of %v, retrying in: %v, cursor: %v, error: %v", attempt, policy.MaxAttempts, backoff, cursor, err)
+		metrics.RetriedDeltas.Inc()
+		select {
+		case <-time.After(backoff):
+		case <-m.stopping:
+			log.Warnf("Handler stopped, aborting retries of delta, cursor: %v, error: %v", cursor, err)
+			return false
+		}
+	}
+	if err != nil {
+		if policy.Action != config.ErrorPolicyAction_Skip {
+			log.Panicf(err, "Failed processing delta: %v, cursor: %v, fork step: %v", delta, cursor, forkStep)
+		}
+		err = documentBeat.StoreDeadLetter(newDeadLetter(delta, cursor, forkStep, err), contractConfig)
+		if err != nil {
+			log.Panicf(err, "Failed storing dead letter for delta: %v, cursor: %v, fork step: %v", delta, cursor, forkStep)
+		}
+		metrics.DeadLetters.Inc()
+		err = documentBeat.Checkpoint(cursor, uint64(delta.Block.Number))
+		if err != nil {
+			log.Panicf(err, "Failed to checkpoint cursor: %v", cursor)
+		}
+	}
+	return true
+}
+
+// Processes a job dispatched to a contract worker using the worker document beat
+func (m *DeltaHandler) processJob(worker *Worker, job *Job) {
+	if m.Err() != nil {
+		return
+	}
+	documentBeat := m.workerBeats[worker.Contract]
+	if job.Delta == nil {
+		err := documentBeat.Checkpoint(job.Cursor, job.BlockNum)
+		if err != nil {
+			if documentBeat.Batch != nil && documentBeat.Batch.Err() != nil {
+				m.fail(err)
+				return
+			}
+			log.Panicf(err, "Failed to checkpoint cursor: %v, worker: %v", job.Cursor, worker.Contract)
+		}
+		return
+	}
+	m.handleDelta(documentBeat, job.Delta, job.Cursor, job.ForkStep)
+}
+
+// Persists the cursor up to which the deltas of all contract workers have been committed
+func (m *DeltaHandler) persistCursor(cursor string, blockNum uint64) error {
+	log.Debugf("Persisting committed cursor: %v, block: %v", cursor, blockNum)
+	return m.DocumentBeat.Checkpointer.Persist(cursor)
+}
+
+// Determines what the delta operation is and calls the corresponding DocumentBeat method
+func (m *DeltaHandler) ProcessDelta(delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep) error {
+	return m.processDelta(m.DocumentBeat, delta, cursor, forkStep)
+}
+
+func (m *DeltaHandler) processDelta(documentBeat *beat.DocumentBeat, delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep) error {
+	switch forkStep {
+	case pbbstream.ForkStep_STEP_IRREVERSIBLE:
+		documentBeat.MarkIrreversible(uint64(delta.Block.Number))
+		metrics.IrreversibleBlockNumber.Set(float64(delta.Block.Number))
+		return nil
+	case pbbstream.ForkStep_STEP_UNDO:
+		undone, err := documentBeat.UndoBlock(uint64(delta.Block.Number), delta.Block.Id, cursor)
+		if err != nil {
+			return fmt.Errorf("failed to undo block: %v, id: %v, error: %v", delta.Block.Number, delta.Block.Id, err)
+		}
+		if undone {
+			return nil
+		}
+	default:
+		err := documentBeat.BeginBlock(uint64(delta.Block.Number), delta.Block.Id)
+		if err != nil {
+			return fmt.Errorf("failed to begin block: %v, id: %v, error: %v", delta.Block.Number, delta.Block.Id, err)
+		}
+	}
+	contractConfig := m.Config.Contracts.Get(delta.Code)
+	if contractConfig != nil {
+		err := documentBeat.PurgePendingEdges(contractConfig)
+		if err != nil {
+			return fmt.Errorf("failed to purge pending edges, contract: %v, error: %v", contractConfig.Name, err)
+		}
+		if contractConfig.DocTableName == delta.TableName {
+			chainDoc := &domain.ChainDocument{}
+			switch delta.Operation {
+			case pbcodec.DBOp_OPERATION_INSERT, pbcodec.DBOp_OPERATION_UPDATE:
+				err := json.Unmarshal(delta.NewData, chainDoc)
+				if err != nil {
+					return &permanentError{fmt.Errorf("error unmarshalling doc new data: %v, error: %v", string(delta.NewData), err)}
+				}
+				log.Tracef("Storing doc: %v ", chainDoc)
+				resolved := documentBeat.ResolvedEdges()
+				err = documentBeat.StoreDocument(chainDoc, cursor, contractConfig)
+				if err != nil {
+					return fmt.Errorf("failed to store doc: %v, error: %v", chainDoc, err)
+				}
+				metrics.CreatedDocs.Inc()
+				atomic.AddUint64(&m.Stats.CreatedDocs, 1)
+				if resolved = documentBeat.ResolvedEdges() - resolved; resolved > 0 {
+					metrics.CreatedEdges.Add(float64(resolved))
+					atomic.AddUint64(&m.Stats.CreatedEdges, resolved)
+				}
+			case pbcodec.DBOp_OPERATION_REMOVE:
+				err := json.Unmarshal(delta.OldData, chainDoc)
+				if err != nil {
+					return &permanentError{fmt.Errorf("error unmarshalling doc old data: %v, error: %v", string(delta.OldData), err)}
+				}
+				err = documentBeat.DeleteDocument(chainDoc, cursor, contractConfig)
+				if err != nil {
+					return fmt.Errorf("failed to delete doc: %v, error: %v", chainDoc, err)
+				}
+				metrics.DeletedDocs.Inc()
+				atomic.AddUint64(&m.Stats.DeletedDocs, 1)
+			}
+		} else if contractConfig.EdgeTableName == delta.TableName {
+			switch delta.Operation {
+			case pbcodec.DBOp_OPERATION_INSERT, pbcodec.DBOp_OPERATION_REMOVE:
+				var (
+					deltaData []byte
+					deleteOp  bool
+				)
+				if delta.Operation == pbcodec.DBOp_OPERATION_INSERT {
+					deltaData = delta.NewData
+				} else {
+					deltaData = delta.OldData
+					deleteOp = true
+				}
+				chainEdge, createdDate, err := beat.UnmarshalChainEdge(deltaData)
+				if err != nil {
+					return &permanentError{fmt.Errorf("error unmarshalling edge data: %v, error: %v", string(deltaData), err)}
+				}
+				queued := documentBeat.QueuedEdges()
+				err = documentBeat.MutateEdge(chainEdge, createdDate, deleteOp, cursor, contractConfig)
+				if err != nil {
+					return fmt.Errorf("failed to mutate doc, deleteOp: %v, edge: %v, error: %v", deleteOp, chainEdge, err)
+				}
+				// Queued edges are counted as created once they are resolved
+				if deleteOp {
+					metrics.DeletedEdges.Inc()
+					atomic.AddUint64(&m.Stats.DeletedEdges, 1)
+				} else if documentBeat.QueuedEdges() == queued {
+					metrics.CreatedEdges.Inc()
+					atomic.AddUint64(&m.Stats.CreatedEdges, 1)
+				}
+
+			case pbcodec.DBOp_OPERATION_UPDATE:
+				oldChainEdge := &domain.ChainEdge{}
+				err := json.Unmarshal(delta.OldData, oldChainEdge)
+				if err != nil {
+					return &permanentError{fmt.Errorf("error unmarshalling edge old data: %v, error: %v", string(delta.OldData), err)}
+				}
+				newChainEdge, createdDate, err := beat.UnmarshalChainEdge(delta.NewData)
+				if err != nil {
+					return &permanentError{fmt.Errorf("error unmarshalling edge new data: %v, error: %v", string(delta.NewData), err)}
+				}
+				err = documentBeat.UpdateEdge(oldChainEdge, newChainEdge, createdDate, cursor, contractConfig)
+				if err != nil {
+					return fmt.Errorf("failed to update edge, old edge: %v, new edge: %v, error: %v", oldChainEdge, newChainEdge, err)
+				}
+				metrics.UpdatedEdges.Inc()
+				atomic.AddUint64(&m.Stats.UpdatedEdges, 1)
+			}
+		}
+	}
+	return nil
+}
+
+// Called every certain amount of blocks and its useful to update the cursor when there are
+// no deltas of interest for a long time
+func (m *DeltaHandler) OnHeartBeat(block *pbcodec.Block, cursor string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stopped {
+		return
+	}
+	m.updateCatchUp(block)
+	var err error
+	if m.pool != nil {
+		err = m.pool.Dispatch("", &Job{
+			Cursor:   cursor,
+			BlockNum: uint64(block.Number),
+		})
+	} else {
+		err = m.DocumentBeat.Checkpoint(cursor, uint64(block.Number))
+	}
+	if err != nil {
+		log.Panicf(err, "Failed to checkpoint cursor: %v", cursor)
+	}
+	metrics.BlockNumber.Set(float64(block.Number))
+	m.cursor = cursor
+	m.onProgress()
+}
+
+// Enters or exits catch up mode based on the time of the block being processed, blocks without
+// header, like the ones of dead letters, are ignored
+func (m *DeltaHandler) updateCatchUp(block *pbcodec.Block) {
+	if !m.Config.CatchUp.Enabled || block.Header == nil || block.Header.Timestamp == nil {
+		return
+	}
+	blockTime, err := block.Time()
+	if err != nil {
+		log.Warnf("Unable to get time of block: %v, error: %v", block.Number, err)
+		return
+	}
+	err = m.DocumentBeat.CatchUp.Update(blockTime)
+	if err != nil {
+		log.Panicf(err, "Failed updating catch up mode, block: %v", block.Number)
+	}
+}
+
+// Called every time the stream makes progress, indicates the stream is connected
+func (m *DeltaHandler) onProgress() {
+	if m.failures > 0 {
+		log.Infof("Stream recovered after: %v failures", m.failures)
+	}
+	m.failures = 0
+	metrics.StreamConnected.Set(1)
+}
+
+// Stops processing deltas, waits for the delta in process to finish and persists the cursor
+// of the last fully processed delta, a delta that is waiting to be retried is aborted. If the
+// processing failed with a fatal error the cursor is not persisted
+func (m *DeltaHandler) Stop() error {
+	m.stopOnce.Do(func() {
+		close(m.stopping)
+	})
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stopped = true
+	if err := m.Err(); err != nil {
+		if m.pool != nil {
+			m.pool.Stop()
+		}
+		log.Warnf("Delta processing failed, not persisting the cursor, error: %v", err)
+		return nil
+	}
+	if m.pool != nil {
+		return m.stopWorkers()
+	}
+	if m.cursor == "" {
+		return nil
+	}
+	log.Infof("Persisting last processed cursor: %v", m.cursor)
+	err := m.DocumentBeat.Checkpointer.Persist(m.cursor)
+	if err != nil {
+		return err
+	}
+	return m.DocumentBeat.Flush()
+}
+
+// Stops the contract workers and commits the deltas they have processed, the persisted cursor is
+// the last one up to which the deltas of all workers have been committed
+func (m *DeltaHandler) stopWorkers() error {
+	m.pool.Stop()
+	for contract, documentBeat := range m.workerBeats {
+		err := documentBeat.Checkpointer.Flush()
+		if err != nil {
+			return fmt.Errorf("failed committing processed deltas of worker: %v, error: %v", contract, err)
+		}
+	}
+	log.Infof("Workers stopped, last committed cursor: %v", m.pool.Cursor())
+	return m.DocumentBeat.Flush()
+}
+
+// Returns a channel that receives the fatal error that stopped the processing of deltas, the stream
+// should be stopped when it is received
+func (m *DeltaHandler) Fatal() <-chan error {
+	return m.fatal
+}
+
+// Returns the fatal error that stopped the processing of deltas, nil if there is none
+func (m *DeltaHandler) Err() error {
+	m.fatalLock.Lock()
+	defer m.fatalLock.Unlock()
+	return m.fatalErr
+}
+
+// Stops the processing of deltas with a fatal error, only the first error is reported
+func (m *DeltaHandler) fail(err error) {
+	m.fatalLock.Lock()
+	defer m.fatalLock.Unlock()
+	if m.fatalErr != nil {
+		return
+	}
+	log.Error(err, "Fatal error processing deltas, stopping")
+	m.fatalErr = err
+	m.fatal <- err
+}
+
+// Called when there is an error with the stream connection
+func (m *DeltaHandler) OnError(err error) {
+	log.Error(err, "On Error")
+	m.lastErr = err
+	metrics.StreamConnected.Set(0)
+}
+
+// Called when the requested stream completes, only happens when a stop block is configured
+func (m *DeltaHandler) OnComplete(lastBlockRef bstream.BlockRef) {
+	log.Infof("On Complete Last Block Ref: %v", lastBlockRef)
+	metrics.BlockNumber.Set(float64(lastBlockRef.Num()))
+	m.completed = true
+}
+
+// Streams the deltas specified in the request from the source, when the stream fails it reconnects
+// from the last processed cursor using exponential backoff with jitter. Returns when the stream completes,
+// the handler is stopped, or with an error once the max consecutive failures is reached or a fatal error occurs
+func (m *DeltaHandler) Stream(deltaSource source.DeltaSource, request *dfclient.DeltaStreamRequest) error {
+	reconnect := &m.Config.Reconnect
+	for {
+		deltaSource.DeltaStream(request, m)
+		metrics.StreamConnected.Set(0)
+		if err := m.Err(); err != nil {
+			return err
+		}
+		m.lock.Lock()
+		stopped := m.stopped
+		m.lock.Unlock()
+		if m.completed || stopped {
+			if m.completed && m.pool != nil {
+				m.pool.Wait()
+			}
+			return nil
+		}
+		m.failures++
+		if m.failures >= reconnect.MaxAttempts {
+			return fmt.Errorf("stream failed: %v consecutive times, last cursor: %v, last error: %v", m.failures, m.cursor, m.lastErr)
+		}
+		if m.cursor != "" {
+			request.StartCursor = m.cursor
+		}
+		backoff := reconnect.JitteredBackoff(m.failures)
+		log.Warnf("Stream failed, reconnecting from cursor: %v in: %v, failure: %v of %v, error: %v", request.StartCursor, backoff, m.failures, reconnect.MaxAttempts, m.lastErr)
+		time.Sleep(backoff)
+		metrics.StreamReconnects.Inc()
+	}
+}
+
+// Replays the dead lettered deltas of all contracts through the delta processing logic, the
+// dead letters that are successfully processed are deleted. Dead letters for which any write is skipped
+// because the document has a newer version are kept, as they were not fully applied. The current cursor
+// is kept, so this should be run while the stream process is stopped
+func (m *DeltaHandler) ReplayDeadLetters() error {
+	pageSize := 100
+	for _, contractConfig := range m.Config.Contracts {
+		replayed, failed, conflicted := 0, 0, 0
+		for {
+			deadLetters, err := m.DocumentBeat.GetDeadLetters(contractConfig, failed+conflicted, pageSize)
+			if err != nil {
+				return fmt.Errorf("failed getting dead letters for contract: %v, error: %v", contractConfig.Name, err)
+			}
+			if len(deadLetters) == 0 {
+				break
+			}
+			for _, deadLetter := range deadLetters {
+				log.Infof("Replaying dead letter: %v", deadLetter)
+				forkStep := pbbstream.ForkStep(pbbstream.ForkStep_value[deadLetter.ForkStep])
+				conflicts := m.DocumentBeat.Conflicts()
+				err = m.ProcessDelta(deadLetterToDelta(deadLetter), m.DocumentBeat.Cursor, forkStep)
+				if err != nil {
+					log.Errorf(err, "Failed replaying dead letter: %v", deadLetter)
+					failed++
+					continue
+				}
+				err = m.DocumentBeat.Flush()
+				if err != nil {
+					return fmt.Errorf("failed flushing replayed dead letter: %v, error: %v", deadLetter, err)
+				}
+				if skipped := m.DocumentBeat.Conflicts() - conflicts; skipped > 0 {
+					log.Warnf("Dead letter: %v was not fully applied, skipped writes: %v as the documents have newer versions, keeping it", deadLetter, skipped)
+					conflicted++
+					continue
+				}
+				err = m.DocumentBeat.DeleteDeadLetter(deadLetter, contractConfig)
+				if err != nil {
+					return fmt.Errorf("failed deleting replayed dead letter: %v, error: %v", deadLetter, err)
+				}
+				metrics.ReplayedDeadLetters.Inc()
+				replayed++
+			}
+		}
+		log.Infof("Replayed dead letters for contract: %v, replayed: %v, failed: %v, kept due to version conflicts: %v", contractConfig.Name, replayed, failed, conflicted)
+	}
+	return nil
+}
+
+// Indicates that an error will occur again if the delta is reprocessed, so it should not be retried
+type permanentError struct {
+	err error
+}
+
+func (m *permanentError) Error() string {
+	return m.err.Error()
+}
+
+func isPermanent(err error) bool {
+	_, ok := err.(*permanentError)
+	return ok
+}
+
+// Creates a dead letter for a delta that could not be processed
+func newDeadLetter(delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep, err error) *beat.DeadLetter {
+	return &beat.DeadLetter{
+		Contract:    delta.Code,
+		TableName:   delta.TableName,
+		Operation:   delta.Operation.String(),
+		PrimaryKey:  delta.PrimaryKey,
+		OldData:     string(delta.OldData),
+		NewData:     string(delta.NewData),
+		BlockNum:    uint64(delta.Block.Number),
+		BlockId:     delta.Block.Id,
+		Cursor:      cursor,
+		ForkStep:    forkStep.String(),
+		Error:       err.Error(),
+		CreatedDate: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// Recreates the delta stored in a dead letter
+func deadLetterToDelta(deadLetter *beat.DeadLetter) *dfclient.TableDelta {
+	delta := &dfclient.TableDelta{
+		Operation:  pbcodec.DBOp_Operation(pbcodec.DBOp_Operation_value[deadLetter.Operation]),
+		Code:       deadLetter.Contract,
+		TableName:  deadLetter.TableName,
+		PrimaryKey: deadLetter.PrimaryKey,
+		Block: &pbcodec.Block{
+			Id:     deadLetter.BlockId,
+			Number: uint32(deadLetter.BlockNum),
+		},
+	}
+	if deadLetter.OldData != "" {
+		delta.OldData = []byte(deadLetter.OldData)
+	}
+	if deadLetter.NewData != "" {
+		delta.NewData = []byte(deadLetter.NewData)
+	}
+	return delta
+}
@@ -0,0 +1,181 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"testing"
+
+	pbcodec "github.com/dfuse-io/dfuse-eosio/pb/dfuse/eosio/codec/v1"
+	"github.com/sebastianmontero/dfuse-firehose-client/dfclient"
+	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/handler"
+	"github.com/sebastianmontero/document-graph-elasticsearch/service"
+	"github.com/sebastianmontero/document-graph-elasticsearch/source"
+	"github.com/sebastianmontero/hypha-document-cache-gql-go/doccache/domain"
+	pbbstream "github.com/streamingfast/pbgo/dfuse/bstream/v1"
+	"gotest.tools/assert"
+)
+
+var contractConfig *config.ContractConfig
+
+func getBaseConfig() *config.Config {
+	contractConfig = &config.ContractConfig{
+		Name:          "contract1",
+		DocTableName:  "documents",
+		EdgeTableName: "edges",
+		IndexPrefix:   "test-handler",
+	}
+	contractConfig.Init()
+	return &config.Config{
+		Contracts: config.ContractsConfig{
+			"contract1": contractConfig,
+		},
+		CursorIndexPrefix: "test-handler",
+		ElasticEndpoint:   "https://localhost:9200",
+		ElasticCA:         "/home/sebastian/vsc-workspace/elastic-helm-charts/elasticsearch/examples/security/elastic-certificate.pem",
+		ElasticUser:       "elastic",
+		ElasticPassword:   "8GXQlCxXy0p8bSilFMqI",
+		CursorIndexName:   "test-handler-cursor",
+		Reconnect: config.RetryConfig{
+			MaxAttempts: 1,
+		},
+	}
+}
+
+func setup(t *testing.T, cfg *config.Config) *handler.DeltaHandler {
+	elasticSearch, err := service.NewElasticSearch(cfg)
+	if err != nil {
+		log.Fatal(err, "Failed creating elasticSearch client")
+	}
+	exists, err := elasticSearch.IndexExists(contractConfig.IndexName)
+	assert.NilError(t, err)
+	if exists {
+		_, err := elasticSearch.DeleteIndex(contractConfig.IndexName)
+		assert.NilError(t, err)
+	}
+	docbeat, err := beat.NewDocumentBeat(elasticSearch, cfg, nil)
+	if err != nil {
+		log.Fatal(err, "Failed creating docbeat client")
+	}
+	err = docbeat.DeleteCursorIndex()
+	assert.NilError(t, err)
+	return handler.NewDeltaHandler(docbeat, cfg, nil)
+}
+
+func TestDeltaHandlerRoutesDeltas(t *testing.T) {
+	cfg := getBaseConfig()
+	deltaHandler := setup(t, cfg)
+
+	memorySource := source.NewMemorySource()
+	memorySource.Add(getDocDelta(t, pbcodec.DBOp_OPERATION_INSERT, getDoc(1), 1), "cursor1", pbbstream.ForkStep_STEP_NEW)
+	memorySource.Add(getDocDelta(t, pbcodec.DBOp_OPERATION_INSERT, getDoc(2), 1), "cursor2", pbbstream.ForkStep_STEP_NEW)
+	memorySource.Add(getEdgeDelta(pbcodec.DBOp_OPERATION_INSERT, "member", 1, 2, 2), "cursor3", pbbstream.ForkStep_STEP_NEW)
+	memorySource.Add(getEdgeDelta(pbcodec.DBOp_OPERATION_REMOVE, "member", 1, 2, 3), "cursor4", pbbstream.ForkStep_STEP_NEW)
+	memorySource.Add(getDocDelta(t, pbcodec.DBOp_OPERATION_REMOVE, getDoc(2), 4), "cursor5", pbbstream.ForkStep_STEP_NEW)
+
+	request := &dfclient.DeltaStreamRequest{
+		ForkSteps: []pbbstream.ForkStep{pbbstream.ForkStep_STEP_NEW},
+	}
+	request.AddTables("contract1", []string{"documents", "edges"})
+	err := deltaHandler.Stream(memorySource, request)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, deltaHandler.Stats, handler.DeltaStats{
+		CreatedDocs:  2,
+		DeletedDocs:  1,
+		CreatedEdges: 1,
+		DeletedEdges: 1,
+	})
+	assert.Equal(t, deltaHandler.Cursor(), "cursor5")
+
+	doc, err := deltaHandler.DocumentBeat.GetDocument("1", contractConfig.IndexName, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, doc != nil)
+	doc, err = deltaHandler.DocumentBeat.GetDocument("2", contractConfig.IndexName, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, doc == nil)
+}
+
+func TestDeltaHandlerStreamFailsAfterMaxAttempts(t *testing.T) {
+	cfg := getBaseConfig()
+	deltaHandler := setup(t, cfg)
+
+	memorySource := source.NewMemorySource()
+	memorySource.Add(getDocDelta(t, pbcodec.DBOp_OPERATION_INSERT, getDoc(1), 1), "cursor1", pbbstream.ForkStep_STEP_NEW)
+	memorySource.Err = fmt.Errorf("connection lost")
+
+	request := &dfclient.DeltaStreamRequest{}
+	request.AddTables("contract1", []string{"documents", "edges"})
+	err := deltaHandler.Stream(memorySource, request)
+	assert.ErrorContains(t, err, "connection lost")
+	assert.Equal(t, deltaHandler.Cursor(), "cursor1")
+}
+
+func getDocDelta(t *testing.T, operation pbcodec.DBOp_Operation, doc *domain.ChainDocument, blockNum uint32) *dfclient.TableDelta {
+	data, err := json.Marshal(doc)
+	assert.NilError(t, err)
+	delta := &dfclient.TableDelta{
+		Operation: operation,
+		Code:      "contract1",
+		TableName: "documents",
+		Block:     getBlock(blockNum),
+	}
+	if operation == pbcodec.DBOp_OPERATION_REMOVE {
+		delta.OldData = data
+	} else {
+		delta.NewData = data
+	}
+	return delta
+}
+
+func getEdgeDelta(operation pbcodec.DBOp_Operation, name string, from, to uint64, blockNum uint32) *dfclient.TableDelta {
+	data := []byte(fmt.Sprintf(`{"edge_name": "%v", "from_node": %v, "to_node": %v}`, name, from, to))
+	delta := &dfclient.TableDelta{
+		Operation: operation,
+		Code:      "contract1",
+		TableName: "edges",
+		Block:     getBlock(blockNum),
+	}
+	if operation == pbcodec.DBOp_OPERATION_REMOVE {
+		delta.OldData = data
+	} else {
+		delta.NewData = data
+	}
+	return delta
+}
+
+func getBlock(blockNum uint32) *pbcodec.Block {
+	return &pbcodec.Block{
+		Id:     fmt.Sprintf("block%v", blockNum),
+		Number: blockNum,
+	}
+}
+
+func getDoc(docId uint64) *domain.ChainDocument {
+	return &domain.ChainDocument{
+		ID:          docId,
+		CreatedDate: "2020-11-12T19:27:47.000",
+		UpdatedDate: "2020-11-12T19:27:47.000",
+		Creator:     "dao1",
+		Contract:    "contract1",
+		ContentGroups: [][]*domain.ChainContent{
+			{
+				{
+					Label: "content_group_label",
+					Value: []interface{}{
+						"string",
+						"system",
+					},
+				},
+				{
+					Label: "type",
+					Value: []interface{}{
+						"name",
+						"dao",
+					},
+				},
+			},
+		},
+	}
+}
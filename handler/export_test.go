@@ -0,0 +1,6 @@
+package handler
+
+// Returns whether Stop has been called, so that the tests can synchronise with it
+func (m *WorkerPool) IsStopped() bool {
+	return m.isStopped()
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/sebastianmontero/dfuse-firehose-client/dfclient"
+	"github.com/sebastianmontero/document-graph-elasticsearch/monitoring/metrics"
+	pbbstream "github.com/streamingfast/pbgo/dfuse/bstream/v1"
+)
+
+// Job is a delta to be processed by a contract worker, a job without delta is a heartbeat
+type Job struct {
+	Delta    *dfclient.TableDelta
+	Cursor   string
+	ForkStep pbbstream.ForkStep
+	BlockNum uint64
+	position *position
+}
+
+// Tracks a stream position until all the workers it was dispatched to have committed it
+type position struct {
+	cursor   string
+	blockNum uint64
+	pending  int
+}
+
+// Worker processes the jobs of a contract in the order they were dispatched
+type Worker struct {
+	Contract  string
+	pool      *WorkerPool
+	queue     chan *Job
+	processed []*Job
+	done      chan struct{}
+}
+
+// Indicates that the writes of all the processed jobs up to the one with the specified cursor
+// have been flushed, so the jobs are considered committed
+func (m *Worker) Commit(cursor string) error {
+	for i, job := range m.processed {
+		if job.Cursor == cursor {
+			committed := m.processed[:i+1]
+			m.processed = m.processed[i+1:]
+			return m.pool.commit(committed)
+		}
+	}
+	log.Warnf("Cursor: %v not found in processed jobs of worker: %v, ignoring commit", cursor, m.Contract)
+	return nil
+}
+
+func (m *Worker) run() {
+	defer close(m.done)
+	for job := range m.queue {
+		metrics.WorkerQueueDepth.WithLabelValues(m.Contract).Set(float64(len(m.queue)))
+		if !m.pool.isStopped() {
+			m.processed = append(m.processed, job)
+			m.pool.process(m, job)
+		}
+		m.pool.pending.Done()
+	}
+}
+
+// WorkerPool dispatches the jobs of each contract to a dedicated worker with a bounded queue, so that
+// contracts are processed in parallel while the order within each contract is preserved. The committed
+// cursor only advances once the jobs of all workers up to that cursor have been committed
+type WorkerPool struct {
+	Workers   map[string]*Worker
+	process   func(worker *Worker, job *Job)
+	onCommit  func(cursor string, blockNum uint64) error
+	lock      sync.Mutex
+	positions []*position
+	cursor    string
+	stopped   bool
+	pending   sync.WaitGroup
+}
+
+// NewWorkerPool creates a worker for each contract, process is called from the worker goroutine for
+// each job, and onCommit every time the committed cursor advances. Start has to be called for the jobs
+// to be processed
+func NewWorkerPool(contracts []string, queueSize uint, process func(worker *Worker, job *Job), onCommit func(cursor string, blockNum uint64) error) *WorkerPool {
+	pool := &WorkerPool{
+		Workers:   make(map[string]*Worker, len(contracts)),
+		process:   process,
+		onCommit:  onCommit,
+		positions: make([]*position, 0),
+	}
+	for _, contract := range contracts {
+		pool.Workers[contract] = &Worker{
+			Contract:  contract,
+			pool:      pool,
+			queue:     make(chan *Job, queueSize),
+			processed: make([]*Job, 0),
+			done:      make(chan struct{}),
+		}
+	}
+	return pool
+}
+
+// Starts the worker goroutines
+func (m *WorkerPool) Start() {
+	for _, worker := range m.Workers {
+		go worker.run()
+	}
+}
+
+// Adds the job to the queue of the contract worker, blocks if the queue is full. Jobs for contracts
+// without a worker are committed right away, heartbeats are dispatched to all workers by not specifying
+// the contract. Returns an error if the committed cursor advances and it can not be stored
+func (m *WorkerPool) Dispatch(contract string, job *Job) error {
+	workers := make([]*Worker, 0, len(m.Workers))
+	if contract == "" {
+		for _, worker := range m.Workers {
+			workers = append(workers, worker)
+		}
+	} else if worker, ok := m.Workers[contract]; ok {
+		workers = append(workers, worker)
+	}
+	m.lock.Lock()
+	if m.stopped {
+		m.lock.Unlock()
+		return nil
+	}
+	job.position = &position{
+		cursor:   job.Cursor,
+		blockNum: job.BlockNum,
+		pending:  len(workers),
+	}
+	m.positions = append(m.positions, job.position)
+	err := m.advance()
+	m.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, worker := range workers {
+		m.pending.Add(1)
+		worker.queue <- job
+		metrics.WorkerQueueDepth.WithLabelValues(worker.Contract).Set(float64(len(worker.queue)))
+	}
+	return nil
+}
+
+// Waits until all the dispatched jobs have been processed
+func (m *WorkerPool) Wait() {
+	m.pending.Wait()
+}
+
+// Stops the workers once the job in process finishes, the queued jobs are discarded and
+// their cursors are never committed
+func (m *WorkerPool) Stop() {
+	m.lock.Lock()
+	if m.stopped {
+		m.lock.Unlock()
+		return
+	}
+	m.stopped = true
+	m.lock.Unlock()
+	for _, worker := range m.Workers {
+		close(worker.queue)
+	}
+	for _, worker := range m.Workers {
+		<-worker.done
+	}
+}
+
+// Returns the last cursor up to which the jobs of all workers have been committed
+func (m *WorkerPool) Cursor() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.cursor
+}
+
+func (m *WorkerPool) isStopped() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stopped
+}
+
+func (m *WorkerPool) commit(jobs []*Job) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, job := range jobs {
+		job.position.pending--
+	}
+	return m.advance()
+}
+
+// Advances the committed cursor to the last position that has been committed by all the workers,
+// and for which all the previous positions have been committed as well
+func (m *WorkerPool) advance() error {
+	var last *position
+	for len(m.positions) > 0 && m.positions[0].pending == 0 {
+		last = m.positions[0]
+		m.positions = m.positions[1:]
+	}
+	if last == nil {
+		return nil
+	}
+	m.cursor = last.cursor
+	return m.onCommit(last.cursor, last.blockNum)
+}
@@ -0,0 +1,214 @@
+package handler_test
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/sebastianmontero/dfuse-firehose-client/dfclient"
+	"github.com/sebastianmontero/document-graph-elasticsearch/handler"
+	"gotest.tools/assert"
+)
+
+// Records the processed and committed jobs of a worker pool, the jobs of the gated contracts block until
+// their gate is closed. The commits and any error found from the worker goroutines are sent over channels
+// so that they are asserted in the test goroutine
+type poolRecorder struct {
+	lock      sync.Mutex
+	gates     map[string]chan struct{}
+	started   chan string
+	processed map[string][]int
+	done      map[int]int
+	workers   map[int]int
+	commits   chan int
+	errs      chan error
+}
+
+func newPoolRecorder(gates map[string]chan struct{}, jobs int) *poolRecorder {
+	return &poolRecorder{
+		gates:     gates,
+		started:   make(chan string, jobs),
+		processed: make(map[string][]int),
+		done:      make(map[int]int),
+		workers:   make(map[int]int),
+		commits:   make(chan int, jobs),
+		errs:      make(chan error, 2*jobs),
+	}
+}
+
+func (m *poolRecorder) process(worker *handler.Worker, job *handler.Job) {
+	m.started <- worker.Contract
+	if gate, ok := m.gates[worker.Contract]; ok {
+		<-gate
+	}
+	seq := getSeq(job.Cursor)
+	m.lock.Lock()
+	if job.Delta != nil {
+		m.processed[worker.Contract] = append(m.processed[worker.Contract], seq)
+	}
+	m.done[seq]++
+	m.lock.Unlock()
+	if err := worker.Commit(job.Cursor); err != nil {
+		m.errs <- err
+	}
+}
+
+// Verifies that all the jobs up to the committed cursor have been processed
+func (m *poolRecorder) onCommit(cursor string, blockNum uint64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	seq := getSeq(cursor)
+	for i := 1; i <= seq; i++ {
+		if m.done[i] != m.workers[i] {
+			m.errs <- fmt.Errorf("job: %v not processed before committing cursor: %v", i, cursor)
+			break
+		}
+	}
+	m.commits <- seq
+	return nil
+}
+
+func (m *poolRecorder) dispatch(pool *handler.WorkerPool, seq int, contract string) error {
+	m.lock.Lock()
+	switch {
+	case contract == "":
+		m.workers[seq] = len(pool.Workers)
+	case pool.Workers[contract] != nil:
+		m.workers[seq] = 1
+	}
+	m.lock.Unlock()
+	job := &handler.Job{
+		Cursor:   getCursor(seq),
+		BlockNum: uint64(seq),
+	}
+	if contract != "" {
+		job.Delta = &dfclient.TableDelta{Code: contract}
+	}
+	return pool.Dispatch(contract, job)
+}
+
+// Waits for the commits until the specified cursor is committed, returns the received commits
+func (m *poolRecorder) waitCommit(seq int) []int {
+	commits := make([]int, 0)
+	for committed := range m.commits {
+		commits = append(commits, committed)
+		if committed >= seq {
+			break
+		}
+	}
+	return commits
+}
+
+// Returns the remaining commits and errors, once the pool has been stopped
+func (m *poolRecorder) drain() ([]int, []error) {
+	close(m.commits)
+	close(m.errs)
+	commits := make([]int, 0)
+	for committed := range m.commits {
+		commits = append(commits, committed)
+	}
+	errs := make([]error, 0)
+	for err := range m.errs {
+		errs = append(errs, err)
+	}
+	return commits, errs
+}
+
+func TestWorkerPoolWithSlowWorker(t *testing.T) {
+	gate := make(chan struct{})
+	recorder := newPoolRecorder(map[string]chan struct{}{"slow": gate}, 100)
+	pool := handler.NewWorkerPool([]string{"fast", "slow"}, 60, recorder.process, recorder.onCommit)
+	pool.Start()
+
+	expected := map[string][]int{}
+	for seq := 1; seq <= 60; seq++ {
+		contract := "fast"
+		switch {
+		case seq%20 == 0:
+			contract = ""
+		case seq%7 == 0:
+			contract = "unknown"
+		case seq%3 == 0:
+			contract = "slow"
+		}
+		if contract == "fast" || contract == "slow" {
+			expected[contract] = append(expected[contract], seq)
+		}
+		assert.NilError(t, recorder.dispatch(pool, seq, contract))
+	}
+	commits := recorder.waitCommit(2)
+	assert.Equal(t, commits[len(commits)-1], 2)
+	assert.Equal(t, pool.Cursor(), getCursor(2), "cursor should not advance past the first job of the blocked worker")
+
+	close(gate)
+	pool.Wait()
+	pool.Stop()
+	remaining, errs := recorder.drain()
+	commits = append(commits, remaining...)
+
+	assert.Equal(t, len(errs), 0, "errors: %v", errs)
+	for i := 1; i < len(commits); i++ {
+		assert.Assert(t, commits[i] > commits[i-1], "committed cursor went backwards: %v", commits)
+	}
+	assert.DeepEqual(t, recorder.processed, expected)
+	assert.Equal(t, pool.Cursor(), getCursor(60))
+	assert.Equal(t, commits[len(commits)-1], 60)
+}
+
+func TestWorkerPoolStopDoesNotCommitQueuedJobs(t *testing.T) {
+	gate := make(chan struct{})
+	recorder := newPoolRecorder(map[string]chan struct{}{"slow": gate}, 20)
+	pool := handler.NewWorkerPool([]string{"fast", "slow"}, 10, recorder.process, recorder.onCommit)
+	pool.Start()
+
+	for seq := 1; seq <= 10; seq++ {
+		contract := "fast"
+		if seq%2 == 0 {
+			contract = "slow"
+		}
+		assert.NilError(t, recorder.dispatch(pool, seq, contract))
+	}
+	// The fast worker processes all its jobs while the slow one is blocked on its first job
+	fast, slow := 0, 0
+	for fast < 5 || slow < 1 {
+		if <-recorder.started == "fast" {
+			fast++
+		} else {
+			slow++
+		}
+	}
+	commits := recorder.waitCommit(1)
+	assert.Equal(t, commits[len(commits)-1], 1)
+
+	stopped := make(chan struct{})
+	go func() {
+		pool.Stop()
+		close(stopped)
+	}()
+	for !pool.IsStopped() {
+		runtime.Gosched()
+	}
+	close(gate)
+	<-stopped
+	remaining, errs := recorder.drain()
+	commits = append(commits, remaining...)
+
+	assert.Equal(t, len(errs), 0, "errors: %v", errs)
+	assert.DeepEqual(t, commits, []int{1, 3})
+	assert.Equal(t, pool.Cursor(), getCursor(3))
+	assert.DeepEqual(t, recorder.processed, map[string][]int{
+		"fast": {1, 3, 5, 7, 9},
+		"slow": {2},
+	})
+}
+
+func getCursor(seq int) string {
+	return fmt.Sprintf("cursor%v", seq)
+}
+
+func getSeq(cursor string) int {
+	var seq int
+	fmt.Sscanf(cursor, "cursor%d", &seq)
+	return seq
+}
@@ -7,7 +7,17 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Creates the prometheus metrics server, ListenAndServe has to be called for it to start
+// serving requests and Shutdown to stop it
+func NewEndpoint(port uint) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%v", port),
+		Handler: mux,
+	}
+}
+
 func SetupEndpoint(port uint) error {
-	http.Handle("/metrics", promhttp.Handler())
-	return http.ListenAndServe(fmt.Sprintf(":%v", port), nil)
+	return NewEndpoint(port).ListenAndServe()
 }
@@ -1,6 +1,9 @@
 package metrics
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -22,8 +25,104 @@ var (
 		Name: "document_graph_elasticsearch_deleted_edges",
 		Help: "# of deleted edges",
 	})
+	UpdatedEdges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_updated_edges",
+		Help: "# of updated edges",
+	})
+	RetriedDeltas = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_retried_deltas",
+		Help: "# of delta processing retries",
+	})
+	DeadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_dead_letters",
+		Help: "# of deltas stored in the dead letter index",
+	})
+	ReplayedDeadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_replayed_dead_letters",
+		Help: "# of dead letters successfully replayed",
+	})
+	StreamReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_stream_reconnects",
+		Help: "# of stream reconnection attempts",
+	})
+	StreamConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "document_graph_elasticsearch_stream_connected",
+		Help: "Whether the stream is connected, 1 connected, 0 disconnected",
+	})
 	BlockNumber = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "document_graph_elasticsearch_block_number",
 		Help: "Block Number",
 	})
+	IrreversibleBlockNumber = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "document_graph_elasticsearch_irreversible_block_number",
+		Help: "Last irreversible block number",
+	})
+	BulkRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_bulk_requests",
+		Help: "# of bulk requests",
+	})
+	BulkFailedOperations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_bulk_failed_operations",
+		Help: "# of bulk operations that failed",
+	})
+	MetadataCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_metadata_cache_hits",
+		Help: "# of document metadata cache hits",
+	})
+	MetadataCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_metadata_cache_misses",
+		Help: "# of document metadata cache misses",
+	})
+	VersionConflicts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_version_conflicts",
+		Help: "# of document writes skipped because the stored document has the same or a newer version",
+	})
+	WorkerQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "document_graph_elasticsearch_worker_queue_depth",
+		Help: "# of deltas waiting to be processed by the contract worker",
+	}, []string{"contract"})
+	CatchUpMode = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "document_graph_elasticsearch_catch_up_mode",
+		Help: "Whether the indexes are in catch up mode, 1 catching up, 0 normal",
+	})
+	CatchUpTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_catch_up_transitions",
+		Help: "# of transitions into and out of catch up mode",
+	}, []string{"mode"})
+	PendingEdges = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "document_graph_elasticsearch_pending_edges",
+		Help: "# of edges waiting for their FROM or TO document to be stored",
+	}, []string{"contract"})
+	ExpiredPendingEdges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_expired_pending_edges",
+		Help: "# of pending edges dropped because their documents were not stored within the expiry blocks",
+	}, []string{"contract"})
+	ProjectionPropagations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_projection_propagations",
+		Help: "# of linking documents updated because a projected field of the document they link to changed",
+	}, []string{"contract"})
+	ProjectionPropagationsTruncated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "document_graph_elasticsearch_projection_propagations_truncated",
+		Help: "# of document updates whose linking documents exceeded the max propagation and were only partially updated",
+	}, []string{"contract"})
+	CheckpointAge = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "document_graph_elasticsearch_checkpoint_age_seconds",
+		Help: "Seconds since the cursor was last persisted",
+	}, checkpointAge)
 )
+
+// Unix time in nanoseconds of the last time the cursor was persisted
+var lastCheckpoint int64
+
+// Sets the time at which the cursor was last persisted, used to calculate the checkpoint age
+func SetLastCheckpoint(t time.Time) {
+	atomic.StoreInt64(&lastCheckpoint, t.UnixNano())
+}
+
+func checkpointAge() float64 {
+	last := atomic.LoadInt64(&lastCheckpoint)
+	if last == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, last)).Seconds()
+}
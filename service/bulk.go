@@ -0,0 +1,245 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+type BulkAction string
+
+var (
+	BulkAction_Index  BulkAction = "index"
+	BulkAction_Update BulkAction = "update"
+	BulkAction_Delete BulkAction = "delete"
+
+	VersionType_External    = "external"
+	VersionType_ExternalGte = "external_gte"
+)
+
+// BulkOperation is a single operation of a bulk request
+type BulkOperation struct {
+	Action     BulkAction
+	Index      string
+	DocumentId string
+	Body       interface{}
+	// Optional external version of index and delete operations
+	Version     *int
+	VersionType string
+	// Optional source fields to return in the result of update operations
+	Fields []string
+}
+
+// Sets the version and version type of the operation
+func (m *BulkOperation) WithVersion(version int, versionType string) *BulkOperation {
+	m.Version = &version
+	m.VersionType = versionType
+	return m
+}
+
+// Sets the source fields to return in the result of an update operation
+func (m *BulkOperation) WithFields(fields []string) *BulkOperation {
+	m.Fields = fields
+	return m
+}
+
+// NewIndexOperation creates an operation that creates or replaces a document
+func NewIndexOperation(index, documentId string, doc interface{}) *BulkOperation {
+	return &BulkOperation{
+		Action:     BulkAction_Index,
+		Index:      index,
+		DocumentId: documentId,
+		Body:       doc,
+	}
+}
+
+// NewUpdateOperation creates an operation that partially updates a document
+func NewUpdateOperation(index, documentId string, update interface{}, upsert bool) *BulkOperation {
+	opType := "doc"
+	if upsert {
+		opType = "doc_as_upsert"
+	}
+	return &BulkOperation{
+		Action:     BulkAction_Update,
+		Index:      index,
+		DocumentId: documentId,
+		Body: map[string]interface{}{
+			opType: update,
+		},
+	}
+}
+
+// NewScriptedUpdateOperation creates an operation that updates a document using a painless script
+func NewScriptedUpdateOperation(index, documentId, source string, params map[string]interface{}) *BulkOperation {
+	return &BulkOperation{
+		Action:     BulkAction_Update,
+		Index:      index,
+		DocumentId: documentId,
+		Body: map[string]interface{}{
+			"script": NewScript(source, params),
+		},
+	}
+}
+
+// NewDeleteOperation creates an operation that deletes a document
+func NewDeleteOperation(index, documentId string) *BulkOperation {
+	return &BulkOperation{
+		Action:     BulkAction_Delete,
+		Index:      index,
+		DocumentId: documentId,
+	}
+}
+
+func (m *BulkOperation) String() string {
+	return fmt.Sprintf("BulkOperation{Action: %v, Index: %v, DocumentId: %v, Body: %v}", m.Action, m.Index, m.DocumentId, m.Body)
+}
+
+// BulkItemError is the failure of a single operation of a bulk request
+type BulkItemError struct {
+	Operation *BulkOperation
+	Status    int
+	Type      string
+	Reason    string
+}
+
+func (m *BulkItemError) Error() string {
+	return fmt.Sprintf("failed bulk operation: %v, status: %v, type: %v, reason: %v", m.Operation, m.Status, m.Type, m.Reason)
+}
+
+// Returns whether the operation could succeed if retried, too many requests and server errors are retryable
+func (m *BulkItemError) IsRetryable() bool {
+	return m.Status == 429 || m.Status >= 500
+}
+
+// Returns whether the operation failed because the document has the same or a newer version
+func (m *BulkItemError) IsVersionConflict() bool {
+	return m.Status == 409 && m.Operation.Version != nil
+}
+
+// BulkError is returned when one or more operations of a bulk request fail
+type BulkError struct {
+	Items      []*BulkItemError
+	Operations int
+}
+
+func (m *BulkError) Error() string {
+	errors := make([]string, 0, len(m.Items))
+	for _, item := range m.Items {
+		errors = append(errors, item.Error())
+	}
+	return fmt.Sprintf("failed %v of %v bulk operations, errors: [%v]", len(m.Items), m.Operations, strings.Join(errors, ", "))
+}
+
+// Executes the operations in a single bulk request, if any of the operations fails a BulkError
+// with the details of each failed operation is returned
+func (m *ElasticSearch) Bulk(operations []*BulkOperation) (map[string]interface{}, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, operation := range operations {
+		metadata := map[string]interface{}{
+			"_index": operation.Index,
+			"_id":    operation.DocumentId,
+		}
+		if operation.Version != nil {
+			metadata["version"] = *operation.Version
+			metadata["version_type"] = operation.VersionType
+		}
+		if len(operation.Fields) > 0 {
+			metadata["_source"] = operation.Fields
+		}
+		meta := map[string]interface{}{
+			string(operation.Action): metadata,
+		}
+		err := encoder.Encode(meta)
+		if err != nil {
+			return nil, fmt.Errorf("failed marshalling bulk operation: %v, error: %v", operation, err)
+		}
+		if operation.Action != BulkAction_Delete {
+			err = encoder.Encode(operation.Body)
+			if err != nil {
+				return nil, fmt.Errorf("failed marshalling bulk operation: %v, error: %v", operation, err)
+			}
+		}
+	}
+	req := esapi.BulkRequest{
+		Body:    &body,
+		Refresh: m.refreshPolicy(),
+	}
+	res, err := req.Do(context.Background(), m.Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed executing bulk request, operations: %v, error: %v", len(operations), err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("failed executing bulk request, operations: %v, status: %v", len(operations), res.Status())
+	}
+	// Deserialize the response into a map.
+	var r map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing the response body from bulk request, operations: %v, error: %v", len(operations), err)
+	}
+	if hasErrors, _ := r["errors"].(bool); hasErrors {
+		return r, newBulkError(operations, r)
+	}
+	return r, nil
+}
+
+// Returns the result of each operation of a bulk response, in the same order as the operations in the request
+func BulkItemResults(res map[string]interface{}) []map[string]interface{} {
+	items, _ := res["items"].([]interface{})
+	results := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		var itemResult map[string]interface{}
+		if item, ok := item.(map[string]interface{}); ok {
+			for _, result := range item {
+				itemResult, _ = result.(map[string]interface{})
+			}
+		}
+		results = append(results, itemResult)
+	}
+	return results
+}
+
+// Creates a bulk error from the failed items of a bulk response, items are returned in the
+// same order as the operations in the request
+func newBulkError(operations []*BulkOperation, res map[string]interface{}) *BulkError {
+	bulkError := &BulkError{
+		Items:      make([]*BulkItemError, 0),
+		Operations: len(operations),
+	}
+	items, _ := res["items"].([]interface{})
+	for i, item := range items {
+		if i >= len(operations) {
+			break
+		}
+		item, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, result := range item {
+			result, ok := result.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			e, ok := result["error"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			itemError := &BulkItemError{
+				Operation: operations[i],
+			}
+			if status, ok := result["status"].(float64); ok {
+				itemError.Status = int(status)
+			}
+			itemError.Type, _ = e["type"].(string)
+			itemError.Reason, _ = e["reason"].(string)
+			bulkError.Items = append(bulkError.Items, itemError)
+		}
+	}
+	return bulkError
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"sync/atomic"
 
 	elasticsearch7 "github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
@@ -15,6 +16,10 @@ import (
 // Provides convenience methods for interacting with elastic search
 type ElasticSearch struct {
 	Client *elasticsearch7.Client
+	// Refresh policy used by the write operations
+	Refresh string
+	// Set to 1 while the write operations should not wait for or force a refresh
+	refreshSuspended int32
 }
 
 func NewElasticSearch(config *config.Config) (*ElasticSearch, error) {
@@ -41,22 +46,52 @@ func NewElasticSearch(config *config.Config) (*ElasticSearch, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed creating elastic search client, error: %v", err)
 	}
+	refresh := string(config.Refresh)
+	if refresh == "" {
+		refresh = "true"
+	}
 	return &ElasticSearch{
-		Client: client,
+		Client:  client,
+		Refresh: refresh,
 	}, nil
 }
 
+// Suspends or resumes the refresh policy of the write operations, while suspended the writes neither wait
+// for nor force a refresh, used while the index refreshes are disabled as waiting for one would block the writes
+func (m *ElasticSearch) SuspendRefresh(suspend bool) {
+	var suspended int32
+	if suspend {
+		suspended = 1
+	}
+	atomic.StoreInt32(&m.refreshSuspended, suspended)
+}
+
+func (m *ElasticSearch) refreshPolicy() string {
+	if atomic.LoadInt32(&m.refreshSuspended) == 1 {
+		return "false"
+	}
+	return m.Refresh
+}
+
 // Creates or updates a document
 func (m *ElasticSearch) Upsert(index, documentId string, doc interface{}) (map[string]interface{}, error) {
+	return m.UpsertWithVersion(index, documentId, doc, nil, "")
+}
+
+// Creates or updates a document using the specified version and version type, if the write is rejected
+// because the stored document has a newer version a VersionConflictError is returned
+func (m *ElasticSearch) UpsertWithVersion(index, documentId string, doc interface{}, version *int, versionType string) (map[string]interface{}, error) {
 	marshalledDoc, err := json.Marshal(doc)
 	if err != nil {
 		return nil, fmt.Errorf("failed marshalling document: %v to json for index: %v, error: %v", doc, index, err)
 	}
 	req := esapi.IndexRequest{
-		Index:      index,
-		DocumentID: documentId,
-		Body:       strings.NewReader(string(marshalledDoc)),
-		Refresh:    "true",
+		Index:       index,
+		DocumentID:  documentId,
+		Body:        strings.NewReader(string(marshalledDoc)),
+		Refresh:     m.refreshPolicy(),
+		Version:     version,
+		VersionType: versionType,
 	}
 	res, err := req.Do(context.Background(), m.Client)
 	if err != nil {
@@ -64,6 +99,9 @@ func (m *ElasticSearch) Upsert(index, documentId string, doc interface{}) (map[s
 	}
 	defer res.Body.Close()
 	if res.IsError() {
+		if isVersionConflictError(res) {
+			return nil, &VersionConflictError{Index: index, DocumentId: documentId, Version: version}
+		}
 		return nil, fmt.Errorf("failed upserting document: %s in index: %v, status: %v", marshalledDoc, index, res.Status())
 	}
 	// Deserialize the response into a map.
@@ -93,7 +131,7 @@ func (m *ElasticSearch) Update(index, documentId string, update interface{}, ups
 		Index:      index,
 		DocumentID: documentId,
 		Body:       strings.NewReader(string(marshalledDoc)),
-		Refresh:    "true",
+		Refresh:    m.refreshPolicy(),
 	}
 	res, err := req.Do(context.Background(), m.Client)
 	if err != nil {
@@ -112,7 +150,114 @@ func (m *ElasticSearch) Update(index, documentId string, update interface{}, ups
 	return r, nil
 }
 
-// Retrieves a document by id
+// Updates a document using a painless script, when ifSeqNo and ifPrimaryTerm are specified the update is
+// only applied if the document has not been modified since that sequence number, otherwise a VersionConflictError is returned.
+// The specified fields of the updated document are returned in the get._source property of the result
+func (m *ElasticSearch) UpdateByScript(index, documentId, source string, params map[string]interface{}, fields []string, ifSeqNo, ifPrimaryTerm *int) (map[string]interface{}, error) {
+	body := map[string]interface{}{
+		"script": NewScript(source, params),
+	}
+	marshalledBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshalling script update: %v to json for index: %v, error: %v", body, index, err)
+	}
+	req := esapi.UpdateRequest{
+		Index:         index,
+		DocumentID:    documentId,
+		Body:          strings.NewReader(string(marshalledBody)),
+		IfSeqNo:       ifSeqNo,
+		IfPrimaryTerm: ifPrimaryTerm,
+		Source:        fields,
+		Refresh:       m.refreshPolicy(),
+	}
+	res, err := req.Do(context.Background(), m.Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed updating document: %v by script: %s in index: %v, error: %v", documentId, marshalledBody, index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		if isVersionConflictError(res) {
+			return nil, &VersionConflictError{Index: index, DocumentId: documentId}
+		}
+		return nil, fmt.Errorf("failed updating document: %v by script: %s in index: %v, status: %v", documentId, marshalledBody, index, res.Status())
+	}
+	// Deserialize the response into a map.
+	var r map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing the response body from updating by script, index: %v, document: %v, error: %v", index, documentId, err)
+	}
+	return r, nil
+}
+
+// Creates the body of a painless script
+func NewScript(source string, params map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"source": source,
+		"lang":   "painless",
+		"params": params,
+	}
+}
+
+// Updates all the documents that match the query using the script specified in the body
+func (m *ElasticSearch) UpdateByQuery(index string, body interface{}) (map[string]interface{}, error) {
+	marshalledBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshalling update by query body: %v to json for index: %v, error: %v", body, index, err)
+	}
+	refresh := true
+	req := esapi.UpdateByQueryRequest{
+		Index:     []string{index},
+		Body:      strings.NewReader(string(marshalledBody)),
+		Conflicts: "proceed",
+		Refresh:   &refresh,
+	}
+	res, err := req.Do(context.Background(), m.Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed updating by query: %s in index: %v, error: %v", marshalledBody, index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("failed updating by query: %s in index: %v, status: %v", marshalledBody, index, res.Status())
+	}
+	// Deserialize the response into a map.
+	var r map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing the response body from updating by query, index: %v, body: %v, error: %v", index, marshalledBody, err)
+	}
+	return r, nil
+}
+
+// Deletes all the documents that match the query specified in the body
+func (m *ElasticSearch) DeleteByQuery(index string, body interface{}) (map[string]interface{}, error) {
+	marshalledBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshalling delete by query body: %v to json for index: %v, error: %v", body, index, err)
+	}
+	req := esapi.DeleteByQueryRequest{
+		Index:     []string{index},
+		Body:      strings.NewReader(string(marshalledBody)),
+		Conflicts: "proceed",
+	}
+	res, err := req.Do(context.Background(), m.Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed deleting by query: %s in index: %v, error: %v", marshalledBody, index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("failed deleting by query: %s in index: %v, status: %v", marshalledBody, index, res.Status())
+	}
+	// Deserialize the response into a map.
+	var r map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing the response body from deleting by query, index: %v, body: %v, error: %v", index, marshalledBody, err)
+	}
+	return r, nil
+}
+
+// Retrieves a document by id, returns nil if the document does not exist
 func (m *ElasticSearch) Get(index, documentId string, fields []string) (map[string]interface{}, error) {
 
 	req := esapi.GetRequest{
@@ -126,6 +271,9 @@ func (m *ElasticSearch) Get(index, documentId string, fields []string) (map[stri
 	}
 	defer res.Body.Close()
 	if res.IsError() {
+		if isNotExistsError(res) {
+			return nil, nil
+		}
 		return nil, fmt.Errorf("failed getting document: %s from index: %v, status: %v", documentId, index, res.Status())
 	}
 	// Deserialize the response into a map.
@@ -137,6 +285,33 @@ func (m *ElasticSearch) Get(index, documentId string, fields []string) (map[stri
 	return r["_source"].(map[string]interface{}), nil
 }
 
+// Searches an index using the query specified in the body
+func (m *ElasticSearch) Search(index string, body interface{}) (map[string]interface{}, error) {
+	marshalledBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshalling search body: %v to json for index: %v, error: %v", body, index, err)
+	}
+	req := esapi.SearchRequest{
+		Index: []string{index},
+		Body:  strings.NewReader(string(marshalledBody)),
+	}
+	res, err := req.Do(context.Background(), m.Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed searching: %s in index: %v, error: %v", marshalledBody, index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("failed searching: %s in index: %v, status: %v", marshalledBody, index, res.Status())
+	}
+	// Deserialize the response into a map.
+	var r map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing the response body from searching, index: %v, body: %v, error: %v", index, marshalledBody, err)
+	}
+	return r, nil
+}
+
 // Deletes the specified index
 func (m *ElasticSearch) DeleteIndex(index string) (map[string]interface{}, error) {
 
@@ -160,6 +335,29 @@ func (m *ElasticSearch) DeleteIndex(index string) (map[string]interface{}, error
 	return r, nil
 }
 
+// Refreshes the specified index, so that the documents written to it are visible to searches
+func (m *ElasticSearch) RefreshIndex(index string) (map[string]interface{}, error) {
+
+	req := esapi.IndicesRefreshRequest{
+		Index: []string{index},
+	}
+	res, err := req.Do(context.Background(), m.Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed refreshing index: %s, error: %v", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("failed refreshing index: %s, status: %v", index, res.Status())
+	}
+	// Deserialize the response into a map.
+	var r map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing the response body from refreshing index, index: %v, error: %v", index, err)
+	}
+	return r, nil
+}
+
 // Checks whether an index exists
 func (m *ElasticSearch) IndexExists(index string) (bool, error) {
 
@@ -203,11 +401,19 @@ func (m *ElasticSearch) DocumentExists(index string, documentId string) (bool, e
 
 // Deletes a document by id
 func (m *ElasticSearch) DeleteDocument(index, documentId string, failIfNotExists bool) (map[string]interface{}, error) {
+	return m.DeleteDocumentWithVersion(index, documentId, failIfNotExists, nil, "")
+}
+
+// Deletes a document by id using the specified version and version type, if the delete is rejected
+// because the stored document has a newer version a VersionConflictError is returned
+func (m *ElasticSearch) DeleteDocumentWithVersion(index, documentId string, failIfNotExists bool, version *int, versionType string) (map[string]interface{}, error) {
 
 	req := esapi.DeleteRequest{
-		Index:      index,
-		DocumentID: documentId,
-		Refresh:    "true",
+		Index:       index,
+		DocumentID:  documentId,
+		Refresh:     m.refreshPolicy(),
+		Version:     version,
+		VersionType: versionType,
 	}
 	res, err := req.Do(context.Background(), m.Client)
 	if err != nil {
@@ -215,6 +421,9 @@ func (m *ElasticSearch) DeleteDocument(index, documentId string, failIfNotExists
 	}
 	defer res.Body.Close()
 	if res.IsError() {
+		if isVersionConflictError(res) {
+			return nil, &VersionConflictError{Index: index, DocumentId: documentId, Version: version}
+		}
 		if failIfNotExists || !isNotExistsError(res) {
 			return nil, fmt.Errorf("failed deleting document: %s from index: %v, status: %v", documentId, index, res.Status())
 		}
@@ -323,6 +532,116 @@ func (m *ElasticSearch) GetMappings(index string) (map[string]interface{}, error
 	return r[index].(map[string]interface{}), nil
 }
 
+// Retrieves the flattened settings of the specified index, e.g. index.refresh_interval
+func (m *ElasticSearch) GetSettings(index string) (map[string]interface{}, error) {
+
+	flatSettings := true
+	req := esapi.IndicesGetSettingsRequest{
+		Index:        []string{index},
+		FlatSettings: &flatSettings,
+	}
+	res, err := req.Do(context.Background(), m.Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting settings: %v, error: %v", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("failed getting settings: %v, status: %v", index, res.Status())
+	}
+	// Deserialize the response into a map.
+	var r map[string]map[string]map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing the response body from getting settings: %v, error: %v", index, err)
+	}
+	return r[index]["settings"], nil
+}
+
+// Updates the dynamic settings of the specified index, a nil setting value resets it to its default
+func (m *ElasticSearch) PutSettings(index string, settings map[string]interface{}) (map[string]interface{}, error) {
+
+	body, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshalling settings: %v for index: %v, error: %v", settings, index, err)
+	}
+	req := esapi.IndicesPutSettingsRequest{
+		Index: []string{index},
+		Body:  strings.NewReader(string(body)),
+	}
+	res, err := req.Do(context.Background(), m.Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed updating settings: %v, body: %s, error: %v", index, body, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("failed updating settings: %v, body: %s, status: %v", index, body, res.Status())
+	}
+	// Deserialize the response into a map.
+	var r map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing the response body from updating settings, index: %v, body: %s, error: %v", index, body, err)
+	}
+	return r, nil
+}
+
 func isNotExistsError(res *esapi.Response) bool {
 	return strings.Contains(res.Status(), "404")
 }
+
+func isVersionConflictError(res *esapi.Response) bool {
+	return res.StatusCode == 409
+}
+
+// VersionConflictError is returned when a versioned write is rejected because the stored
+// document has the same or a newer version
+type VersionConflictError struct {
+	Index      string
+	DocumentId string
+	Version    *int
+}
+
+func (m *VersionConflictError) Error() string {
+	version := "nil"
+	if m.Version != nil {
+		version = fmt.Sprintf("%v", *m.Version)
+	}
+	return fmt.Sprintf("version conflict for document: %v in index: %v, version: %v", m.DocumentId, m.Index, version)
+}
+
+// Returns whether the error is a version conflict
+func IsVersionConflict(err error) bool {
+	_, ok := err.(*VersionConflictError)
+	return ok
+}
+
+// Returns the hits contained in a search response
+func Hits(res map[string]interface{}) []map[string]interface{} {
+	hits := make([]map[string]interface{}, 0)
+	if h, ok := res["hits"].(map[string]interface{}); ok {
+		if hh, ok := h["hits"].([]interface{}); ok {
+			for _, hit := range hh {
+				if hit, ok := hit.(map[string]interface{}); ok {
+					hits = append(hits, hit)
+				}
+			}
+		}
+	}
+	return hits
+}
+
+// Returns the value of a metric aggregation contained in a search response, the path specifies the
+// names of the nested aggregations, returns 0 if the aggregation is not found
+func AggregationValue(res map[string]interface{}, path ...string) float64 {
+	agg, ok := res["aggregations"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	for _, name := range path {
+		if agg, ok = agg[name].(map[string]interface{}); !ok {
+			return 0
+		}
+	}
+	value, _ := agg["value"].(float64)
+	return value
+}
@@ -372,3 +372,194 @@ func TestUpdateMappings(t *testing.T) {
 	assert.Assert(t, strings.Contains(resJSONStr, "\"name\":{\"type\":\"keyword\"}"))
 
 }
+
+func TestUpdateByQuery(t *testing.T) {
+
+	index := "prueba4"
+	doc1Id := "1"
+	doc2Id := "2"
+	doc1 := map[string]interface{}{
+		"str":    "reversible",
+		"number": float64(10),
+	}
+	doc2 := map[string]interface{}{
+		"str":    "reversible",
+		"number": float64(20),
+	}
+
+	exists, err := elasticSearch.IndexExists(index)
+	assert.NilError(t, err)
+	if exists {
+		_, err := elasticSearch.DeleteIndex(index)
+		assert.NilError(t, err)
+	}
+
+	_, err = elasticSearch.Upsert(index, doc1Id, doc1)
+	assert.NilError(t, err)
+	_, err = elasticSearch.Upsert(index, doc2Id, doc2)
+	assert.NilError(t, err)
+
+	body := map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": "ctx._source.str = params.str",
+			"lang":   "painless",
+			"params": map[string]interface{}{
+				"str": "irreversible",
+			},
+		},
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{
+				"number": map[string]interface{}{
+					"lte": 15,
+				},
+			},
+		},
+	}
+	res, err := elasticSearch.UpdateByQuery(index, body)
+	assert.NilError(t, err)
+	assert.Equal(t, res["updated"], float64(1))
+
+	doc1["str"] = "irreversible"
+	actual, err := elasticSearch.Get(index, doc1Id, nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, doc1, actual)
+
+	actual, err = elasticSearch.Get(index, doc2Id, nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, doc2, actual)
+}
+
+func TestUpdateByScript(t *testing.T) {
+
+	index := "prueba6"
+	docId := "1"
+	doc := map[string]interface{}{
+		"str":  "doc 1",
+		"list": []interface{}{"a"},
+	}
+	source := "if (ctx._source.list.contains(params.value)) { ctx.op = 'noop' } else { ctx._source.list.add(params.value) }"
+
+	exists, err := elasticSearch.IndexExists(index)
+	assert.NilError(t, err)
+	if exists {
+		_, err := elasticSearch.DeleteIndex(index)
+		assert.NilError(t, err)
+	}
+
+	res, err := elasticSearch.Upsert(index, docId, doc)
+	assert.NilError(t, err)
+	seqNo := int(res["_seq_no"].(float64))
+	primaryTerm := int(res["_primary_term"].(float64))
+
+	res, err = elasticSearch.UpdateByScript(index, docId, source, map[string]interface{}{"value": "b"}, nil, &seqNo, &primaryTerm)
+	assert.NilError(t, err)
+	assert.Equal(t, res["result"], "updated")
+
+	res, err = elasticSearch.UpdateByScript(index, docId, source, map[string]interface{}{"value": "b"}, []string{"list"}, nil, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, res["result"], "noop")
+	assert.DeepEqual(t, res["get"].(map[string]interface{})["_source"], map[string]interface{}{"list": []interface{}{"a", "b"}})
+
+	_, err = elasticSearch.UpdateByScript(index, docId, source, map[string]interface{}{"value": "c"}, nil, &seqNo, &primaryTerm)
+	assert.Assert(t, service.IsVersionConflict(err))
+
+	doc["list"] = []interface{}{"a", "b"}
+	actual, err := elasticSearch.Get(index, docId, nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, doc, actual)
+}
+
+func TestSettings(t *testing.T) {
+
+	index := "prueba7"
+	exists, err := elasticSearch.IndexExists(index)
+	assert.NilError(t, err)
+	if exists {
+		_, err := elasticSearch.DeleteIndex(index)
+		assert.NilError(t, err)
+	}
+	_, err = elasticSearch.UpsertIndex(index, `{"settings": {"index": {"number_of_replicas": 1}}}`)
+	assert.NilError(t, err)
+
+	settings, err := elasticSearch.GetSettings(index)
+	assert.NilError(t, err)
+	assert.Equal(t, settings["index.number_of_replicas"], "1")
+	_, ok := settings["index.refresh_interval"]
+	assert.Assert(t, !ok)
+
+	_, err = elasticSearch.PutSettings(index, map[string]interface{}{
+		"index.refresh_interval":   "-1",
+		"index.number_of_replicas": 0,
+	})
+	assert.NilError(t, err)
+	settings, err = elasticSearch.GetSettings(index)
+	assert.NilError(t, err)
+	assert.Equal(t, settings["index.refresh_interval"], "-1")
+	assert.Equal(t, settings["index.number_of_replicas"], "0")
+
+	_, err = elasticSearch.PutSettings(index, map[string]interface{}{
+		"index.refresh_interval":   nil,
+		"index.number_of_replicas": 1,
+	})
+	assert.NilError(t, err)
+	settings, err = elasticSearch.GetSettings(index)
+	assert.NilError(t, err)
+	_, ok = settings["index.refresh_interval"]
+	assert.Assert(t, !ok)
+	assert.Equal(t, settings["index.number_of_replicas"], "1")
+}
+
+func TestBulk(t *testing.T) {
+
+	index := "prueba5"
+	doc1 := map[string]interface{}{
+		"str":    "doc 1",
+		"number": float64(10),
+	}
+	doc2 := map[string]interface{}{
+		"str":    "doc 2",
+		"number": float64(20),
+	}
+
+	exists, err := elasticSearch.IndexExists(index)
+	assert.NilError(t, err)
+	if exists {
+		_, err := elasticSearch.DeleteIndex(index)
+		assert.NilError(t, err)
+	}
+
+	_, err = elasticSearch.Bulk([]*service.BulkOperation{
+		service.NewIndexOperation(index, "1", doc1),
+		service.NewIndexOperation(index, "2", doc2),
+		service.NewUpdateOperation(index, "1", map[string]interface{}{"number": float64(15)}, false),
+		service.NewDeleteOperation(index, "2"),
+	})
+	assert.NilError(t, err)
+
+	doc1["number"] = float64(15)
+	actual, err := elasticSearch.Get(index, "1", nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, doc1, actual)
+
+	exists, err = elasticSearch.DocumentExists(index, "2")
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+
+	missingUpdate := service.NewUpdateOperation(index, "3", map[string]interface{}{"number": float64(30)}, false)
+	_, err = elasticSearch.Bulk([]*service.BulkOperation{
+		service.NewIndexOperation(index, "2", doc2),
+		missingUpdate,
+	})
+	bulkError, ok := err.(*service.BulkError)
+	assert.Assert(t, ok)
+	assert.Equal(t, bulkError.Operations, 2)
+	assert.Equal(t, len(bulkError.Items), 1)
+	assert.Equal(t, bulkError.Items[0].Operation, missingUpdate)
+	assert.Equal(t, bulkError.Items[0].Status, 404)
+	assert.Equal(t, bulkError.Items[0].Type, "document_missing_exception")
+	assert.Assert(t, !bulkError.Items[0].IsRetryable())
+
+	actual, err = elasticSearch.Get(index, "2", nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, doc2, actual)
+}
@@ -0,0 +1,131 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var DefaultChainTimeout = 30 * time.Second
+
+// ChainInfo holds the properties of the chain get_info response required to take a snapshot
+type ChainInfo struct {
+	HeadBlockNum             uint64 `json:"head_block_num"`
+	HeadBlockId              string `json:"head_block_id"`
+	LastIrreversibleBlockNum uint64 `json:"last_irreversible_block_num"`
+}
+
+func (m *ChainInfo) String() string {
+	return fmt.Sprintf("ChainInfo{HeadBlockNum: %v, HeadBlockId: %v, LastIrreversibleBlockNum: %v}", m.HeadBlockNum, m.HeadBlockId, m.LastIrreversibleBlockNum)
+}
+
+// TableRowsRequest is the body of a get_table_rows request
+type TableRowsRequest struct {
+	Code       string `json:"code"`
+	Scope      string `json:"scope"`
+	Table      string `json:"table"`
+	LowerBound string `json:"lower_bound,omitempty"`
+	Limit      uint   `json:"limit"`
+	JSON       bool   `json:"json"`
+}
+
+// TableRowsResponse is a page of table rows, if more is true the next page starts at next key
+type TableRowsResponse struct {
+	Rows    []json.RawMessage `json:"rows"`
+	More    bool              `json:"more"`
+	NextKey string            `json:"next_key"`
+}
+
+// ChainClient calls the chain api of the eos endpoint
+type ChainClient struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewChainClient creates a client for the chain api of the specified endpoint
+func NewChainClient(endpoint string) *ChainClient {
+	return &ChainClient{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Client: &http.Client{
+			Timeout: DefaultChainTimeout,
+		},
+	}
+}
+
+// Returns the chain info, including the current head block
+func (m *ChainClient) GetInfo() (*ChainInfo, error) {
+	info := &ChainInfo{}
+	err := m.call("get_info", nil, info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Returns a page of the rows of a table
+func (m *ChainClient) GetTableRows(request *TableRowsRequest) (*TableRowsResponse, error) {
+	response := &TableRowsResponse{}
+	err := m.call("get_table_rows", request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Pages through all the rows of a contract table, calling fn for every row in primary key order
+func (m *ChainClient) ForEachRow(code, table string, pageSize uint, fn func(row json.RawMessage) error) error {
+	request := &TableRowsRequest{
+		Code:  code,
+		Scope: code,
+		Table: table,
+		Limit: pageSize,
+		JSON:  true,
+	}
+	for {
+		response, err := m.GetTableRows(request)
+		if err != nil {
+			return err
+		}
+		for _, row := range response.Rows {
+			err = fn(row)
+			if err != nil {
+				return err
+			}
+		}
+		if !response.More {
+			return nil
+		}
+		if response.NextKey == "" {
+			return fmt.Errorf("failed paging through table: %v of contract: %v, there are more rows but next key was not provided, lower bound: %v", table, code, request.LowerBound)
+		}
+		request.LowerBound = response.NextKey
+	}
+}
+
+func (m *ChainClient) call(method string, request, response interface{}) error {
+	url := fmt.Sprintf("%v/v1/chain/%v", m.Endpoint, method)
+	body := []byte("{}")
+	if request != nil {
+		var err error
+		body, err = json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("failed marshalling request: %v for: %v, error: %v", request, url, err)
+		}
+	}
+	res, err := m.Client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed calling: %v, request: %s, error: %v", url, body, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed calling: %v, request: %s, status: %v", url, body, res.Status)
+	}
+	err = json.NewDecoder(res.Body).Decode(response)
+	if err != nil {
+		return fmt.Errorf("failed parsing the response body from: %v, request: %s, error: %v", url, body, err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package snapshot_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/snapshot"
+	"gotest.tools/assert"
+)
+
+// Stands in for the chain api, serves get_info and pages through the table rows using
+// the row position as key
+type chainStandIn struct {
+	headBlockNum uint64
+	tables       map[string][]json.RawMessage
+	requests     []*snapshot.TableRowsRequest
+	omitNextKey  bool
+}
+
+func newChainStandIn(headBlockNum uint64) *chainStandIn {
+	return &chainStandIn{
+		headBlockNum: headBlockNum,
+		tables:       make(map[string][]json.RawMessage),
+		requests:     make([]*snapshot.TableRowsRequest, 0),
+	}
+}
+
+func (m *chainStandIn) addRow(code, table, row string) {
+	key := fmt.Sprintf("%v/%v", code, table)
+	m.tables[key] = append(m.tables[key], json.RawMessage(row))
+}
+
+func (m *chainStandIn) start() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chain/get_info", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&snapshot.ChainInfo{
+			HeadBlockNum: m.headBlockNum,
+			HeadBlockId:  fmt.Sprintf("block%v", m.headBlockNum),
+		})
+	})
+	mux.HandleFunc("/v1/chain/get_table_rows", func(w http.ResponseWriter, r *http.Request) {
+		request := &snapshot.TableRowsRequest{}
+		err := json.NewDecoder(r.Body).Decode(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.requests = append(m.requests, request)
+		rows := m.tables[fmt.Sprintf("%v/%v", request.Code, request.Table)]
+		start := 0
+		if request.LowerBound != "" {
+			start, _ = strconv.Atoi(request.LowerBound)
+		}
+		end := start + int(request.Limit)
+		if end > len(rows) {
+			end = len(rows)
+		}
+		response := &snapshot.TableRowsResponse{
+			Rows: rows[start:end],
+			More: end < len(rows),
+		}
+		if response.More && !m.omitNextKey {
+			response.NextKey = strconv.Itoa(end)
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestChainClientGetInfo(t *testing.T) {
+	server := newChainStandIn(150).start()
+	defer server.Close()
+
+	info, err := snapshot.NewChainClient(server.URL + "/").GetInfo()
+	assert.NilError(t, err)
+	assert.Equal(t, info.HeadBlockNum, uint64(150))
+	assert.Equal(t, info.HeadBlockId, "block150")
+}
+
+func TestChainClientPagesThroughRows(t *testing.T) {
+	chain := newChainStandIn(150)
+	for i := 1; i <= 5; i++ {
+		chain.addRow("contract1", "documents", fmt.Sprintf(`{"id": %v}`, i))
+	}
+	server := chain.start()
+	defer server.Close()
+
+	ids := make([]int, 0)
+	err := snapshot.NewChainClient(server.URL).ForEachRow("contract1", "documents", 2, func(row json.RawMessage) error {
+		var doc map[string]int
+		err := json.Unmarshal(row, &doc)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, doc["id"])
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ids, []int{1, 2, 3, 4, 5})
+	assert.Equal(t, len(chain.requests), 3)
+	for i, request := range chain.requests {
+		assert.Equal(t, request.Scope, "contract1")
+		assert.Equal(t, request.Limit, uint(2))
+		assert.Equal(t, request.JSON, true)
+		if i > 0 {
+			assert.Equal(t, request.LowerBound, strconv.Itoa(i*2))
+		}
+	}
+}
+
+func TestChainClientFailsWithoutNextKey(t *testing.T) {
+	chain := newChainStandIn(150)
+	for i := 1; i <= 3; i++ {
+		chain.addRow("contract1", "documents", fmt.Sprintf(`{"id": %v}`, i))
+	}
+	chain.omitNextKey = true
+	server := chain.start()
+	defer server.Close()
+
+	err := snapshot.NewChainClient(server.URL).ForEachRow("contract1", "documents", 2, func(row json.RawMessage) error {
+		return nil
+	})
+	assert.ErrorContains(t, err, "next key was not provided")
+}
+
+func TestChainClientFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := snapshot.NewChainClient(server.URL).GetInfo()
+	assert.ErrorContains(t, err, "404")
+}
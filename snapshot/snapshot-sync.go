@@ -0,0 +1,108 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/hypha-document-cache-gql-go/doccache/domain"
+	"github.com/sebastianmontero/slog-go/slog"
+)
+
+var DefaultPageSize uint = 100
+
+var log *slog.Log
+
+// SnapshotSync indexes the current state of the contract documents and edges tables, so that
+// a new index can be bootstrapped without streaming all the blocks since the contract was deployed
+type SnapshotSync struct {
+	Chain        *ChainClient
+	DocumentBeat *beat.DocumentBeat
+	Config       *config.Config
+	// Number of rows requested per get_table_rows call
+	PageSize uint
+}
+
+// NewSnapshotSync creates a snapshot sync that reads the tables using the chain client
+func NewSnapshotSync(chain *ChainClient, documentBeat *beat.DocumentBeat, config *config.Config, logConfig *slog.Config) *SnapshotSync {
+	log = slog.New(logConfig, "snapshot-sync")
+	return &SnapshotSync{
+		Chain:        chain,
+		DocumentBeat: documentBeat,
+		Config:       config,
+		PageSize:     DefaultPageSize,
+	}
+}
+
+// Indexes the documents and then the edges of every contract, returns the head block recorded before
+// reading the tables. As the tables are read after the head block, the stream should be started from the
+// block after it, the deltas already reflected in the snapshot are reapplied which leaves the same state
+func (m *SnapshotSync) Sync() (uint64, error) {
+	info, err := m.Chain.GetInfo()
+	if err != nil {
+		return 0, fmt.Errorf("failed getting chain info, error: %v", err)
+	}
+	log.Infof("Taking snapshot at: %v", info)
+	for _, contractConfig := range m.Config.Contracts {
+		docs, err := m.syncDocuments(contractConfig)
+		if err != nil {
+			return 0, err
+		}
+		edges, err := m.syncEdges(contractConfig)
+		if err != nil {
+			return 0, err
+		}
+		log.Infof("Synced contract: %v, documents: %v, edges: %v", contractConfig.Name, docs, edges)
+	}
+	err = m.DocumentBeat.Flush()
+	if err != nil {
+		return 0, fmt.Errorf("failed flushing snapshot operations, error: %v", err)
+	}
+	err = m.DocumentBeat.StoreSnapshotBlock(info.HeadBlockNum)
+	if err != nil {
+		return 0, err
+	}
+	return info.HeadBlockNum, nil
+}
+
+func (m *SnapshotSync) syncDocuments(contractConfig *config.ContractConfig) (int, error) {
+	count := 0
+	err := m.Chain.ForEachRow(contractConfig.Name, contractConfig.DocTableName, m.PageSize, func(row json.RawMessage) error {
+		chainDoc := &domain.ChainDocument{}
+		err := json.Unmarshal(row, chainDoc)
+		if err != nil {
+			return fmt.Errorf("failed unmarshalling document row: %s, contract: %v, error: %v", row, contractConfig.Name, err)
+		}
+		err = m.DocumentBeat.StoreDocument(chainDoc, "", contractConfig)
+		if err != nil {
+			return fmt.Errorf("failed storing snapshot document: %v, error: %v", chainDoc.GetDocId(), err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed syncing documents of contract: %v, error: %v", contractConfig.Name, err)
+	}
+	return count, nil
+}
+
+func (m *SnapshotSync) syncEdges(contractConfig *config.ContractConfig) (int, error) {
+	count := 0
+	err := m.Chain.ForEachRow(contractConfig.Name, contractConfig.EdgeTableName, m.PageSize, func(row json.RawMessage) error {
+		chainEdge, createdDate, err := beat.UnmarshalChainEdge(row)
+		if err != nil {
+			return fmt.Errorf("failed unmarshalling edge row: %s, contract: %v, error: %v", row, contractConfig.Name, err)
+		}
+		err = m.DocumentBeat.MutateEdge(chainEdge, createdDate, false, "", contractConfig)
+		if err != nil {
+			return fmt.Errorf("failed storing snapshot edge: %v, error: %v", chainEdge, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed syncing edges of contract: %v, error: %v", contractConfig.Name, err)
+	}
+	return count, nil
+}
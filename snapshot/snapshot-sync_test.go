@@ -0,0 +1,111 @@
+package snapshot_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/service"
+	"github.com/sebastianmontero/document-graph-elasticsearch/snapshot"
+	"github.com/sebastianmontero/hypha-document-cache-gql-go/doccache/domain"
+	"gotest.tools/assert"
+)
+
+func getConfig() *config.Config {
+	contractConfig := &config.ContractConfig{
+		Name:          "contract1",
+		DocTableName:  "documents",
+		EdgeTableName: "edges",
+		IndexPrefix:   "test-snapshot",
+	}
+	contractConfig.Init()
+	return &config.Config{
+		Contracts: config.ContractsConfig{
+			"contract1": contractConfig,
+		},
+		CursorIndexPrefix: "test-snapshot",
+		ElasticEndpoint:   "https://localhost:9200",
+		ElasticCA:         "/home/sebastian/vsc-workspace/elastic-helm-charts/elasticsearch/examples/security/elastic-certificate.pem",
+		ElasticUser:       "elastic",
+		ElasticPassword:   "8GXQlCxXy0p8bSilFMqI",
+		CursorIndexName:   "test-snapshot-cursor",
+	}
+}
+
+func TestSnapshotSync(t *testing.T) {
+	cfg := getConfig()
+	contractConfig := cfg.Contracts.Get("contract1")
+	elasticSearch, err := service.NewElasticSearch(cfg)
+	assert.NilError(t, err)
+	for _, index := range []string{contractConfig.IndexName, cfg.CursorIndexName} {
+		exists, err := elasticSearch.IndexExists(index)
+		assert.NilError(t, err)
+		if exists {
+			_, err := elasticSearch.DeleteIndex(index)
+			assert.NilError(t, err)
+		}
+	}
+	docbeat, err := beat.NewDocumentBeat(elasticSearch, cfg, nil)
+	assert.NilError(t, err)
+
+	chain := newChainStandIn(1200)
+	for i := uint64(1); i <= 3; i++ {
+		row, err := json.Marshal(getDoc(i))
+		assert.NilError(t, err)
+		chain.addRow("contract1", "documents", string(row))
+	}
+	chain.addRow("contract1", "edges", `{"edge_name": "member", "from_node": 1, "to_node": 2}`)
+	chain.addRow("contract1", "edges", `{"edge_name": "member", "from_node": 1, "to_node": 3}`)
+	server := chain.start()
+	defer server.Close()
+
+	snapshotSync := snapshot.NewSnapshotSync(snapshot.NewChainClient(server.URL), docbeat, cfg, nil)
+	snapshotSync.PageSize = 2
+	blockNum, err := snapshotSync.Sync()
+	assert.NilError(t, err)
+	assert.Equal(t, blockNum, uint64(1200))
+
+	for i := 1; i <= 3; i++ {
+		doc, err := docbeat.GetDocument(fmt.Sprintf("%v", i), contractConfig.IndexName, nil)
+		assert.NilError(t, err)
+		assert.Assert(t, doc != nil)
+	}
+	doc, err := docbeat.GetDocument("1", contractConfig.IndexName, []string{"edges"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, doc["edges"], map[string]interface{}{
+		"member": []interface{}{"2", "3"},
+	})
+	snapshotBlock, err := docbeat.GetSnapshotBlock()
+	assert.NilError(t, err)
+	assert.Equal(t, snapshotBlock, uint64(1200))
+}
+
+func getDoc(docId uint64) *domain.ChainDocument {
+	return &domain.ChainDocument{
+		ID:          docId,
+		CreatedDate: "2020-11-12T19:27:47.000",
+		UpdatedDate: "2020-11-12T19:27:47.000",
+		Creator:     "dao1",
+		Contract:    "contract1",
+		ContentGroups: [][]*domain.ChainContent{
+			{
+				{
+					Label: "content_group_label",
+					Value: []interface{}{
+						"string",
+						"system",
+					},
+				},
+				{
+					Label: "type",
+					Value: []interface{}{
+						"name",
+						"dao",
+					},
+				},
+			},
+		},
+	}
+}
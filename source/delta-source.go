@@ -0,0 +1,23 @@
+package source
+
+import (
+	"fmt"
+
+	"github.com/sebastianmontero/dfuse-firehose-client/dfclient"
+	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+)
+
+// DeltaSource provides the table deltas to be processed, DeltaStream calls the handler methods
+// as the deltas are received and returns once the stream completes or fails
+type DeltaSource interface {
+	DeltaStream(request *dfclient.DeltaStreamRequest, handler dfclient.DeltaStreamHandler)
+}
+
+// NewDfuseSource creates the default delta source, backed by the dfuse firehose client
+func NewDfuseSource(config *config.Config) (DeltaSource, error) {
+	client, err := dfclient.NewDfClient(config.FirehoseEndpoint, config.DfuseApiKey, config.DfuseAuthURL, config.EosEndpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating dfclient, error: %v", err)
+	}
+	return client, nil
+}
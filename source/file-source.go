@@ -0,0 +1,213 @@
+package source
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	pbcodec "github.com/dfuse-io/dfuse-eosio/pb/dfuse/eosio/codec/v1"
+	"github.com/sebastianmontero/dfuse-firehose-client/dfclient"
+	"github.com/sebastianmontero/slog-go/slog"
+	"github.com/streamingfast/bstream"
+	pbbstream "github.com/streamingfast/pbgo/dfuse/bstream/v1"
+)
+
+var log *slog.Log
+
+// RecordedDelta is the representation of a delta in a recording file, each line of the file
+// holds one recorded delta in json format
+type RecordedDelta struct {
+	Cursor     string `json:"cursor"`
+	ForkStep   string `json:"forkStep"`
+	BlockNum   uint32 `json:"blockNum"`
+	BlockId    string `json:"blockId"`
+	Operation  string `json:"operation"`
+	Code       string `json:"code"`
+	Scope      string `json:"scope"`
+	TableName  string `json:"tableName"`
+	PrimaryKey string `json:"primaryKey"`
+	OldData    string `json:"oldData,omitempty"`
+	NewData    string `json:"newData,omitempty"`
+}
+
+// NewRecordedDelta creates the recorded representation of a delta
+func NewRecordedDelta(delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep) *RecordedDelta {
+	return &RecordedDelta{
+		Cursor:     cursor,
+		ForkStep:   forkStep.String(),
+		BlockNum:   delta.Block.Number,
+		BlockId:    delta.Block.Id,
+		Operation:  delta.Operation.String(),
+		Code:       delta.Code,
+		Scope:      delta.Scope,
+		TableName:  delta.TableName,
+		PrimaryKey: delta.PrimaryKey,
+		OldData:    string(delta.OldData),
+		NewData:    string(delta.NewData),
+	}
+}
+
+// Recreates the delta from its recorded representation
+func (m *RecordedDelta) ToDelta() *Delta {
+	tableDelta := &dfclient.TableDelta{
+		Operation:  pbcodec.DBOp_Operation(pbcodec.DBOp_Operation_value[m.Operation]),
+		Code:       m.Code,
+		Scope:      m.Scope,
+		TableName:  m.TableName,
+		PrimaryKey: m.PrimaryKey,
+		Block: &pbcodec.Block{
+			Id:     m.BlockId,
+			Number: m.BlockNum,
+		},
+	}
+	if m.OldData != "" {
+		tableDelta.OldData = []byte(m.OldData)
+	}
+	if m.NewData != "" {
+		tableDelta.NewData = []byte(m.NewData)
+	}
+	return &Delta{
+		TableDelta: tableDelta,
+		Cursor:     m.Cursor,
+		ForkStep:   pbbstream.ForkStep(pbbstream.ForkStep_value[m.ForkStep]),
+	}
+}
+
+// Recorder wraps a delta stream handler and appends every delta it receives to a recording file
+// before passing it to the wrapped handler
+type Recorder struct {
+	handler dfclient.DeltaStreamHandler
+	file    *os.File
+	encoder *json.Encoder
+	lock    sync.Mutex
+	count   uint64
+}
+
+// NewRecorder creates a recorder that appends the deltas to the specified file, the file is
+// created if it does not exist
+func NewRecorder(path string, handler dfclient.DeltaStreamHandler, logConfig *slog.Config) (*Recorder, error) {
+	log = slog.New(logConfig, "recorder")
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening recording file: %v, error: %v", path, err)
+	}
+	return &Recorder{
+		handler: handler,
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+// Records the delta and passes it to the wrapped handler
+func (m *Recorder) OnDelta(delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep) {
+	m.lock.Lock()
+	err := m.encoder.Encode(NewRecordedDelta(delta, cursor, forkStep))
+	if err != nil {
+		log.Panicf(err, "Failed recording delta: %v, cursor: %v", delta, cursor)
+	}
+	m.count++
+	m.lock.Unlock()
+	m.handler.OnDelta(delta, cursor, forkStep)
+}
+
+func (m *Recorder) OnHeartBeat(block *pbcodec.Block, cursor string) {
+	m.handler.OnHeartBeat(block, cursor)
+}
+
+func (m *Recorder) OnError(err error) {
+	m.handler.OnError(err)
+}
+
+func (m *Recorder) OnComplete(lastBlockRef bstream.BlockRef) {
+	m.handler.OnComplete(lastBlockRef)
+}
+
+// Returns the number of recorded deltas
+func (m *Recorder) Count() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.count
+}
+
+// Closes the recording file
+func (m *Recorder) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.file.Close()
+}
+
+// RecordingSource wraps a delta source so that all the deltas it streams are recorded
+type RecordingSource struct {
+	source DeltaSource
+	path   string
+}
+
+// NewRecordingSource creates a source that records the deltas streamed by source in the specified file
+func NewRecordingSource(source DeltaSource, path string) *RecordingSource {
+	return &RecordingSource{
+		source: source,
+		path:   path,
+	}
+}
+
+// Streams the deltas from the wrapped source recording them, the recording file is closed once the
+// stream returns
+func (m *RecordingSource) DeltaStream(request *dfclient.DeltaStreamRequest, handler dfclient.DeltaStreamHandler) {
+	recorder, err := NewRecorder(m.path, handler, nil)
+	if err != nil {
+		handler.OnError(err)
+		return
+	}
+	m.source.DeltaStream(request, recorder)
+	log.Infof("Recorded: %v deltas to file: %v", recorder.Count(), m.path)
+	err = recorder.Close()
+	if err != nil {
+		handler.OnError(fmt.Errorf("failed closing recording file: %v, error: %v", m.path, err))
+	}
+}
+
+// FileSource streams the deltas stored in a recording file
+type FileSource struct {
+	path string
+}
+
+// NewFileSource creates a source that streams the deltas in the specified recording file
+func NewFileSource(path string) *FileSource {
+	return &FileSource{
+		path: path,
+	}
+}
+
+// Streams the recorded deltas that match the request, the file is read line by line so that
+// big recordings can be replayed
+func (m *FileSource) DeltaStream(request *dfclient.DeltaStreamRequest, handler dfclient.DeltaStreamHandler) {
+	file, err := os.Open(m.path)
+	if err != nil {
+		handler.OnError(fmt.Errorf("failed opening recording file: %v, error: %v", m.path, err))
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	streamDeltas(request, handler, func() (*Delta, error) {
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			recorded := &RecordedDelta{}
+			err := json.Unmarshal(scanner.Bytes(), recorded)
+			if err != nil {
+				return nil, fmt.Errorf("failed unmarshalling recorded delta, file: %v, line: %v, error: %v", m.path, line, err)
+			}
+			return recorded.ToDelta(), nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed reading recording file: %v, error: %v", m.path, err)
+		}
+		return nil, nil
+	}, nil)
+}
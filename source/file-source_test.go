@@ -0,0 +1,80 @@
+package source_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pbcodec "github.com/dfuse-io/dfuse-eosio/pb/dfuse/eosio/codec/v1"
+	"github.com/sebastianmontero/dfuse-firehose-client/dfclient"
+	"github.com/sebastianmontero/document-graph-elasticsearch/source"
+	pbbstream "github.com/streamingfast/pbgo/dfuse/bstream/v1"
+	"gotest.tools/assert"
+)
+
+type deltaHandler struct {
+	recordingHandler
+	deltas []*source.Delta
+}
+
+func (m *deltaHandler) OnDelta(delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep) {
+	m.deltas = append(m.deltas, &source.Delta{
+		TableDelta: delta,
+		Cursor:     cursor,
+		ForkStep:   forkStep,
+	})
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deltas.ndjson")
+
+	memorySource := source.NewMemorySource()
+	insert := getDelta("contract1", "documents", 1)
+	insert.PrimaryKey = "1"
+	insert.NewData = []byte(`{"id":1,"creator":"dao1"}`)
+	memorySource.Add(insert, "c1", pbbstream.ForkStep_STEP_NEW)
+	remove := getDelta("contract1", "edges", 2)
+	remove.Operation = pbcodec.DBOp_OPERATION_REMOVE
+	remove.OldData = []byte(`{"edge_name":"member","from_node":1,"to_node":2}`)
+	memorySource.Add(remove, "c2", pbbstream.ForkStep_STEP_NEW)
+	undo := getDelta("contract1", "documents", 2)
+	memorySource.Add(undo, "c3", pbbstream.ForkStep_STEP_UNDO)
+
+	request := getRequest()
+	request.ForkSteps = []pbbstream.ForkStep{pbbstream.ForkStep_STEP_NEW, pbbstream.ForkStep_STEP_UNDO}
+	recorded := &deltaHandler{}
+	source.NewRecordingSource(memorySource, path).DeltaStream(request, recorded)
+	assert.NilError(t, recorded.err)
+	assert.Equal(t, len(recorded.deltas), 3)
+
+	replayed := &deltaHandler{}
+	source.NewFileSource(path).DeltaStream(request, replayed)
+	assert.NilError(t, replayed.err)
+	assert.Equal(t, replayed.lastBlockRef.Num(), uint64(2))
+	assert.Equal(t, len(replayed.deltas), len(memorySource.Deltas))
+	for i, expected := range memorySource.Deltas {
+		actual := replayed.deltas[i]
+		assert.Equal(t, actual.Cursor, expected.Cursor)
+		assert.Equal(t, actual.ForkStep, expected.ForkStep)
+		assert.Equal(t, actual.TableDelta.String(), expected.TableDelta.String())
+		assert.Equal(t, actual.TableDelta.Block.Number, expected.TableDelta.Block.Number)
+		assert.Equal(t, actual.TableDelta.Block.Id, expected.TableDelta.Block.Id)
+	}
+
+	replayed = &deltaHandler{}
+	request.StartCursor = "c1"
+	request.ForkSteps = []pbbstream.ForkStep{pbbstream.ForkStep_STEP_NEW}
+	source.NewFileSource(path).DeltaStream(request, replayed)
+	assert.Equal(t, len(replayed.deltas), 1)
+	assert.Equal(t, replayed.deltas[0].Cursor, "c2")
+}
+
+func TestReplayMissingFile(t *testing.T) {
+	handler := &recordingHandler{}
+	source.NewFileSource("/non/existent/deltas.ndjson").DeltaStream(getRequest(), handler)
+	assert.ErrorContains(t, handler.err, "failed opening recording file")
+}
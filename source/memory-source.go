@@ -0,0 +1,112 @@
+package source
+
+import (
+	"fmt"
+
+	"github.com/sebastianmontero/dfuse-firehose-client/dfclient"
+	"github.com/streamingfast/bstream"
+	pbbstream "github.com/streamingfast/pbgo/dfuse/bstream/v1"
+)
+
+// Delta is a table delta along with the cursor and fork step with which it was streamed
+type Delta struct {
+	TableDelta *dfclient.TableDelta
+	Cursor     string
+	ForkStep   pbbstream.ForkStep
+}
+
+func (m *Delta) String() string {
+	return fmt.Sprintf("Delta{Cursor: %v, ForkStep: %v, TableDelta: %v}", m.Cursor, m.ForkStep, m.TableDelta)
+}
+
+// MemorySource streams a predefined list of deltas, useful for tests and synthetic loads
+type MemorySource struct {
+	Deltas []*Delta
+	// If set, the handler OnError method is called with it once all the deltas have been streamed
+	// instead of completing the stream
+	Err error
+}
+
+// NewMemorySource creates a source that streams the specified deltas
+func NewMemorySource(deltas ...*Delta) *MemorySource {
+	return &MemorySource{
+		Deltas: deltas,
+	}
+}
+
+// Adds a delta to the source
+func (m *MemorySource) Add(delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep) {
+	m.Deltas = append(m.Deltas, &Delta{
+		TableDelta: delta,
+		Cursor:     cursor,
+		ForkStep:   forkStep,
+	})
+}
+
+// Streams the deltas that match the request tables and fork steps, if the request has a start
+// cursor the deltas up to and including the one with that cursor are skipped, the stream fails if
+// the cursor is not found
+func (m *MemorySource) DeltaStream(request *dfclient.DeltaStreamRequest, handler dfclient.DeltaStreamHandler) {
+	i := 0
+	streamDeltas(request, handler, func() (*Delta, error) {
+		if i >= len(m.Deltas) {
+			return nil, nil
+		}
+		i++
+		return m.Deltas[i-1], nil
+	}, m.Err)
+}
+
+// Streams the deltas returned by next until it returns nil, filtering them based on the request,
+// if next returns an error, err is set or the start cursor is not found the handler OnError method is called
+// instead of completing the stream
+func streamDeltas(request *dfclient.DeltaStreamRequest, handler dfclient.DeltaStreamHandler, next func() (*Delta, error), err error) {
+	skipping := request.StartCursor != ""
+	var lastBlockRef bstream.BlockRef = bstream.BlockRefEmpty
+	for {
+		delta, nextErr := next()
+		if nextErr != nil {
+			handler.OnError(nextErr)
+			return
+		}
+		if delta == nil {
+			break
+		}
+		if skipping {
+			skipping = delta.Cursor != request.StartCursor
+			continue
+		}
+		block := delta.TableDelta.Block
+		if request.StopBlockNum > 0 && uint64(block.Number) > request.StopBlockNum {
+			break
+		}
+		if request.StartBlockNum > 0 && int64(block.Number) < request.StartBlockNum {
+			continue
+		}
+		lastBlockRef = bstream.NewBlockRef(block.Id, uint64(block.Number))
+		if !request.HasTable(delta.TableDelta.Code, delta.TableDelta.TableName) || !hasForkStep(request, delta.ForkStep) {
+			continue
+		}
+		handler.OnDelta(delta.TableDelta, delta.Cursor, delta.ForkStep)
+	}
+	if skipping {
+		handler.OnError(fmt.Errorf("start cursor: %v not found", request.StartCursor))
+		return
+	}
+	if err != nil {
+		handler.OnError(err)
+		return
+	}
+	handler.OnComplete(lastBlockRef)
+}
+func hasForkStep(request *dfclient.DeltaStreamRequest, forkStep pbbstream.ForkStep) bool {
+	if len(request.ForkSteps) == 0 {
+		return forkStep == pbbstream.ForkStep_STEP_NEW
+	}
+	for _, step := range request.ForkSteps {
+		if step == forkStep {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package source_test
+
+import (
+	"errors"
+	"testing"
+
+	pbcodec "github.com/dfuse-io/dfuse-eosio/pb/dfuse/eosio/codec/v1"
+	"github.com/sebastianmontero/dfuse-firehose-client/dfclient"
+	"github.com/sebastianmontero/document-graph-elasticsearch/source"
+	"github.com/streamingfast/bstream"
+	pbbstream "github.com/streamingfast/pbgo/dfuse/bstream/v1"
+	"gotest.tools/assert"
+)
+
+type recordingHandler struct {
+	cursors      []string
+	err          error
+	lastBlockRef bstream.BlockRef
+}
+
+func (m *recordingHandler) OnDelta(delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep) {
+	m.cursors = append(m.cursors, cursor)
+}
+
+func (m *recordingHandler) OnHeartBeat(block *pbcodec.Block, cursor string) {
+}
+
+func (m *recordingHandler) OnError(err error) {
+	m.err = err
+}
+
+func (m *recordingHandler) OnComplete(lastBlockRef bstream.BlockRef) {
+	m.lastBlockRef = lastBlockRef
+}
+
+func getMemorySource() *source.MemorySource {
+	memorySource := source.NewMemorySource()
+	memorySource.Add(getDelta("contract1", "documents", 1), "c1", pbbstream.ForkStep_STEP_NEW)
+	memorySource.Add(getDelta("contract1", "edges", 1), "c2", pbbstream.ForkStep_STEP_NEW)
+	memorySource.Add(getDelta("contract2", "documents", 2), "c3", pbbstream.ForkStep_STEP_NEW)
+	memorySource.Add(getDelta("contract1", "documents", 2), "c4", pbbstream.ForkStep_STEP_UNDO)
+	memorySource.Add(getDelta("contract1", "documents", 3), "c5", pbbstream.ForkStep_STEP_NEW)
+	return memorySource
+}
+
+func getRequest() *dfclient.DeltaStreamRequest {
+	request := &dfclient.DeltaStreamRequest{
+		ForkSteps: []pbbstream.ForkStep{pbbstream.ForkStep_STEP_NEW},
+	}
+	request.AddTables("contract1", []string{"documents", "edges"})
+	return request
+}
+
+func TestMemorySourceFiltersDeltas(t *testing.T) {
+	handler := &recordingHandler{}
+	getMemorySource().DeltaStream(getRequest(), handler)
+	assert.DeepEqual(t, handler.cursors, []string{"c1", "c2", "c5"})
+	assert.NilError(t, handler.err)
+	assert.Equal(t, handler.lastBlockRef.Num(), uint64(3))
+}
+
+func TestMemorySourceResumesFromCursorAndStopsAtStopBlock(t *testing.T) {
+	handler := &recordingHandler{}
+	request := getRequest()
+	request.StartCursor = "c1"
+	request.StopBlockNum = 2
+	getMemorySource().DeltaStream(request, handler)
+	assert.DeepEqual(t, handler.cursors, []string{"c2"})
+	assert.Equal(t, handler.lastBlockRef.Num(), uint64(2))
+}
+
+func TestMemorySourceFailsIfStartCursorNotFound(t *testing.T) {
+	handler := &recordingHandler{}
+	request := getRequest()
+	request.StartCursor = "c10"
+	getMemorySource().DeltaStream(request, handler)
+	assert.Equal(t, len(handler.cursors), 0)
+	assert.Error(t, handler.err, "start cursor: c10 not found")
+	assert.Assert(t, handler.lastBlockRef == nil)
+}
+
+func TestMemorySourceReportsError(t *testing.T) {
+	handler := &recordingHandler{}
+	memorySource := getMemorySource()
+	memorySource.Err = errors.New("stream failed")
+	memorySource.DeltaStream(getRequest(), handler)
+	assert.DeepEqual(t, handler.cursors, []string{"c1", "c2", "c5"})
+	assert.Error(t, handler.err, "stream failed")
+	assert.Assert(t, handler.lastBlockRef == nil)
+}
+
+func getDelta(contract, tableName string, blockNum uint32) *dfclient.TableDelta {
+	return &dfclient.TableDelta{
+		Operation: pbcodec.DBOp_OPERATION_INSERT,
+		Code:      contract,
+		TableName: tableName,
+		Block: &pbcodec.Block{
+			Id:     "block-id",
+			Number: blockNum,
+		},
+	}
+}
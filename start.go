@@ -1,153 +1,110 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	pbcodec "github.com/dfuse-io/dfuse-eosio/pb/dfuse/eosio/codec/v1"
 	"github.com/rs/zerolog"
 	"github.com/sebastianmontero/dfuse-firehose-client/dfclient"
 	"github.com/sebastianmontero/document-graph-elasticsearch/beat"
 	"github.com/sebastianmontero/document-graph-elasticsearch/config"
+	"github.com/sebastianmontero/document-graph-elasticsearch/handler"
 	"github.com/sebastianmontero/document-graph-elasticsearch/monitoring"
-	"github.com/sebastianmontero/document-graph-elasticsearch/monitoring/metrics"
 	"github.com/sebastianmontero/document-graph-elasticsearch/service"
-	"github.com/sebastianmontero/hypha-document-cache-gql-go/doccache/domain"
+	"github.com/sebastianmontero/document-graph-elasticsearch/snapshot"
+	"github.com/sebastianmontero/document-graph-elasticsearch/source"
 	"github.com/sebastianmontero/slog-go/slog"
-	"github.com/streamingfast/bstream"
 	pbbstream "github.com/streamingfast/pbgo/dfuse/bstream/v1"
 )
 
-// Main entry point of the document elastic search stream process, configures the dfuse client and defines the stream handler
+// Main entry point of the document elastic search stream process, configures the delta source and the stream handler
 
 var (
 	log *slog.Log
 )
 
-// Dfuse stream handler, processes the table deltas and calls the correct DocumentBeat methods
-// based on the delta type
-type deltaStreamHandler struct {
-	// Indicates where in the stream we are located
-	cursor string
-	// Processes the operations indicated by the table deltas and updates elastic search to reflect these changes
-	documentBeat *beat.DocumentBeat
-	// Stores the initial configuration information
-	config *config.Config
-}
-
-// Called every time there is a table delta of interest, determines what the operation is and calls the
-// corresponding DocumentBeat method
-func (m *deltaStreamHandler) OnDelta(delta *dfclient.TableDelta, cursor string, forkStep pbbstream.ForkStep) {
-	log.Debugf("On Delta: \nCursor: %v \nFork Step: %v \nDelta %v ", cursor, forkStep, delta)
-	contractConfig := m.config.Contracts.Get(delta.Code)
-	if contractConfig != nil {
-		if contractConfig.DocTableName == delta.TableName {
-			chainDoc := &domain.ChainDocument{}
-			switch delta.Operation {
-			case pbcodec.DBOp_OPERATION_INSERT, pbcodec.DBOp_OPERATION_UPDATE:
-				err := json.Unmarshal(delta.NewData, chainDoc)
-				if err != nil {
-					log.Panicf(err, "Error unmarshalling doc new data: %v", string(delta.NewData))
-				}
-				log.Tracef("Storing doc: %v ", chainDoc)
-				err = m.documentBeat.StoreDocument(chainDoc, cursor, contractConfig)
-				if err != nil {
-					log.Panicf(err, "Failed to store doc: %v", chainDoc)
-				}
-				metrics.CreatedDocs.Inc()
-			case pbcodec.DBOp_OPERATION_REMOVE:
-				err := json.Unmarshal(delta.OldData, chainDoc)
-				if err != nil {
-					log.Panicf(err, "Error unmarshalling doc old data: %v", string(delta.OldData))
-				}
-				err = m.documentBeat.DeleteDocument(chainDoc, cursor, contractConfig)
-				if err != nil {
-					log.Panicf(err, "Failed to delete doc: %v", chainDoc)
-				}
-				metrics.DeletedDocs.Inc()
-			}
-		} else if contractConfig.EdgeTableName == delta.TableName {
-			switch delta.Operation {
-			case pbcodec.DBOp_OPERATION_INSERT, pbcodec.DBOp_OPERATION_REMOVE:
-				var (
-					deltaData []byte
-					deleteOp  bool
-				)
-				chainEdge := &domain.ChainEdge{}
-				if delta.Operation == pbcodec.DBOp_OPERATION_INSERT {
-					deltaData = delta.NewData
-				} else {
-					deltaData = delta.OldData
-					deleteOp = true
-				}
-				err := json.Unmarshal(deltaData, chainEdge)
-				if err != nil {
-					log.Panicf(err, "Error unmarshalling edge data: %v", chainEdge)
-				}
-				err = m.documentBeat.MutateEdge(chainEdge, deleteOp, cursor, contractConfig)
-				if err != nil {
-					log.Panicf(err, "Failed to mutate doc, deleteOp: %v, edge: %v", deleteOp, chainEdge)
-				}
-				if deleteOp {
-					metrics.DeletedEdges.Inc()
-				} else {
-					metrics.CreatedEdges.Inc()
-				}
-
-			case pbcodec.DBOp_OPERATION_UPDATE:
-				log.Panicf(nil, "Edge updating is not handled: %v", delta)
-			}
+// Stops the handler, persisting the last processed cursor, the background processes and the
+// prometheus endpoint, exits with a non zero code if it is not able to do so within the grace period
+func shutdown(deltaHandler *handler.DeltaHandler, endpoint *http.Server, gracePeriod time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		err := deltaHandler.Stop()
+		if err == nil {
+			deltaHandler.DocumentBeat.Close()
 		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Error(err, "Failed stopping delta stream handler")
+			os.Exit(1)
+		}
+	case <-ctx.Done():
+		log.Errorf(ctx.Err(), "Unable to stop delta stream handler within grace period: %v", gracePeriod)
+		os.Exit(1)
 	}
-	metrics.BlockNumber.Set(float64(delta.Block.Number))
-	m.cursor = cursor
-}
-
-// Called every certain amount of blocks and its useful to update the cursor when there are
-// no deltas of interest for a long time
-func (m *deltaStreamHandler) OnHeartBeat(block *pbcodec.Block, cursor string) {
-	err := m.documentBeat.UpdateCursor(cursor)
+	err := endpoint.Shutdown(ctx)
 	if err != nil {
-		log.Panicf(err, "Failed to update cursor: %v", cursor)
+		log.Error(err, "Failed shutting down prometheus endpoint")
 	}
-	metrics.BlockNumber.Set(float64(block.Number))
+	log.Infof("Shutdown complete")
 }
 
-// Called when there is an error with the stream connection
-func (m *deltaStreamHandler) OnError(err error) {
-	log.Error(err, "On Error")
-}
-
-// Called when the requested stream completes, should never be called because there is no
-// final block
-func (m *deltaStreamHandler) OnComplete(lastBlockRef bstream.BlockRef) {
-	log.Infof("On Complete Last Block Ref: %v", lastBlockRef)
-}
-
-// Loads the configuration file, creates a new dfuse client and configures it with the stream handler
-// defined above
+// Loads the configuration file, creates the dfuse delta source and streams the deltas through the delta handler,
+// if the replay-dead-letters command is specified the dead lettered deltas are replayed instead of starting the stream,
+// the record command records the streamed deltas to a file and the replay command streams the deltas from a recorded file,
+// the snapshot-sync command indexes the current state of the contract tables and streams from the snapshot block
 func main() {
 	log = slog.New(&slog.Config{Pretty: true, Level: zerolog.DebugLevel}, "start-document-beat")
-	if len(os.Args) != 2 {
-		log.Panic(nil, "Config file has to be specified as the only cmd argument")
+	startBlock := flag.Int64("start-block", 0, "Block to start from when there is no stored cursor, overrides the start-block config property")
+	stopBlock := flag.Uint64("stop-block", 0, "Block at which to stop processing and exit, overrides the stop-block config property")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [replay-dead-letters | snapshot-sync | record <file> | replay <file>] <config-file>\n", os.Args[0])
+		flag.PrintDefaults()
 	}
-	config, err := config.LoadConfig(os.Args[1])
-	if err != nil {
-		log.Panicf(err, "Unable to load config file: %v", os.Args[1])
+	flag.Parse()
+	command := "stream"
+	args := flag.Args()
+	if len(args) > 1 {
+		command = args[0]
+		args = args[1:]
 	}
-
-	log.Info(config.String())
-
-	go monitoring.SetupEndpoint(config.PrometheusPort)
-	if err != nil {
-		log.Panic(err, "Error seting up prometheus endpoint")
+	var recordingFile string
+	if command == "record" || command == "replay" {
+		if len(args) != 2 {
+			log.Panicf(nil, "The %v command requires the recording file and the config file as arguments", command)
+		}
+		recordingFile = args[0]
+		args = args[1:]
 	}
-
-	client, err := dfclient.NewDfClient(config.FirehoseEndpoint, config.DfuseApiKey, config.DfuseAuthURL, config.EosEndpoint, nil)
+	if len(args) != 1 {
+		log.Panic(nil, "Config file has to be specified as the only cmd argument, optionally preceded by a command")
+	}
+	config, err := config.LoadConfig(args[0])
 	if err != nil {
-		log.Panic(err, "Error creating dfclient")
+		log.Panicf(err, "Unable to load config file: %v", args[0])
 	}
 
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "start-block":
+			config.StartBlock = *startBlock
+		case "stop-block":
+			config.StopBlock = *stopBlock
+		}
+	})
+
+	log.Info(config.String())
+
 	elasticSearch, err := service.NewElasticSearch(config)
 	if err != nil {
 		log.Panic(err, "Error creating elastic search client")
@@ -157,11 +114,76 @@ func main() {
 		log.Panic(err, "Error creating docbeat client")
 	}
 	log.Infof("Cursor: %v", docbeat.Cursor)
+	deltaHandler := handler.NewDeltaHandler(docbeat, config, nil)
+
+	switch command {
+	case "stream", "record", "replay", "snapshot-sync":
+	case "replay-dead-letters":
+		err = deltaHandler.ReplayDeadLetters()
+		docbeat.Close()
+		if err != nil {
+			log.Panic(err, "Failed replaying dead letters")
+		}
+		return
+	default:
+		log.Panicf(nil, "Unknown command: %v", command)
+	}
+
+	endpoint := monitoring.NewEndpoint(config.PrometheusPort)
+	go func() {
+		err := endpoint.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Panic(err, "Error seting up prometheus endpoint")
+		}
+	}()
+
+	startCursor := docbeat.Cursor
+	if command == "snapshot-sync" {
+		if startCursor != "" {
+			log.Panicf(nil, "Snapshot sync is meant to bootstrap new indexes, there is already a stored cursor: %v", startCursor)
+		}
+		snapshotBlock, err := snapshot.NewSnapshotSync(snapshot.NewChainClient(config.EosEndpoint), docbeat, config, nil).Sync()
+		if err != nil {
+			log.Panic(err, "Failed syncing snapshot")
+		}
+		log.Infof("Snapshot synced at block: %v, starting stream from next block", snapshotBlock)
+		config.StartBlock = int64(snapshotBlock + 1)
+	} else if startCursor == "" && command != "replay" {
+		snapshotBlock, err := docbeat.GetSnapshotBlock()
+		if err != nil {
+			log.Panic(err, "Failed getting snapshot block")
+		}
+		if snapshotBlock > 0 && int64(snapshotBlock) >= config.StartBlock {
+			log.Infof("No stored cursor, starting stream after snapshot block: %v", snapshotBlock)
+			config.StartBlock = int64(snapshotBlock + 1)
+		}
+	}
+
+	var deltaSource source.DeltaSource
+	if command == "replay" {
+		log.Infof("Replaying recording file: %v, ignoring stored cursor", recordingFile)
+		deltaSource = source.NewFileSource(recordingFile)
+		startCursor = ""
+	} else {
+		deltaSource, err = source.NewDfuseSource(config)
+		if err != nil {
+			log.Panic(err, "Error creating delta source")
+		}
+		if command == "record" {
+			log.Infof("Recording deltas to file: %v", recordingFile)
+			deltaSource = source.NewRecordingSource(deltaSource, recordingFile)
+		}
+	}
+
+	forkSteps := []pbbstream.ForkStep{pbbstream.ForkStep_STEP_NEW, pbbstream.ForkStep_STEP_UNDO}
+	if config.TrackFinality {
+		forkSteps = append(forkSteps, pbbstream.ForkStep_STEP_IRREVERSIBLE)
+	}
 	deltaRequest := &dfclient.DeltaStreamRequest{
 		StartBlockNum:      config.StartBlock,
-		StartCursor:        docbeat.Cursor,
-		StopBlockNum:       0,
-		ForkSteps:          []pbbstream.ForkStep{pbbstream.ForkStep_STEP_NEW, pbbstream.ForkStep_STEP_UNDO},
+		StartCursor:        startCursor,
+		StopBlockNum:       config.StopBlock,
+		ForkSteps:          forkSteps,
 		ReverseUndoOps:     true,
 		HeartBeatFrequency: config.HeartBeatFrequency,
 	}
@@ -170,8 +192,30 @@ func main() {
 		deltaRequest.AddTables(contract.Name, []string{contract.DocTableName, contract.EdgeTableName})
 	}
 
-	client.DeltaStream(deltaRequest, &deltaStreamHandler{
-		documentBeat: docbeat,
-		config:       config,
-	})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	streamDone := make(chan error, 1)
+	go func() {
+		streamDone <- deltaHandler.Stream(deltaSource, deltaRequest)
+	}()
+	select {
+	case sig := <-signals:
+		log.Infof("Received signal: %v, shutting down, grace period: %v", sig, config.ShutdownGracePeriod)
+	case err := <-deltaHandler.Fatal():
+		log.Error(err, "Delta processing failed, exiting without persisting the cursor")
+		shutdown(deltaHandler, endpoint, config.ShutdownGracePeriod)
+		os.Exit(1)
+	case err := <-streamDone:
+		if err != nil {
+			log.Error(err, "Unable to reconnect to the stream, exiting")
+			shutdown(deltaHandler, endpoint, config.ShutdownGracePeriod)
+			os.Exit(1)
+		}
+		log.Infof("Delta stream finished, shutting down")
+	}
+	shutdown(deltaHandler, endpoint, config.ShutdownGracePeriod)
+	if config.StopBlock > 0 {
+		log.Infof("Processed range, start block: %v, stop block: %v, last cursor: %v", config.StartBlock, config.StopBlock, deltaHandler.Cursor())
+	}
+	log.Infof("Processed deltas: %v", deltaHandler.Stats.String())
 }